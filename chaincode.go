@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"strconv"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -36,6 +41,8 @@ type User struct {
 	Address      string   `json:"address"`
 	PhoneNumber  string   `json:"phoneNumber"`
 	EmailAddress string   `json:"emailAddress"`
+	PersonalDetails *PersonalDetails `json:"personalDetails,omitempty"` //Validated on create and update when present
+	PersonalDetailsHash string   `json:"personalDetailsHash,omitempty"` //What the ledger holds instead, see seal_user_value
 }
 
 type BrokerageRequest struct {
@@ -52,8 +59,355 @@ type BrokerageRequest struct {
 	TimeStamps				string `json:"TimeStamps"`
 	Meeting 			    string `json:"Meeting"`
 	UpdateType              string `json:"UpdateType"`
+	DocVerdict              string `json:"DocVerdict"` //Aggregate verdict of the on-chain document checks
+	KYCPackageRef           string `json:"KYCPackageRef"` //UserId of a shared KyckUser package, see share_kyc_with_broker
+	Risk                    *RiskAssessment `json:"Risk"` //Filled on read from the stored assessment
+	Closure                 *RequestClosure `json:"Closure"` //Set once the request is withdrawn or archived
+	EntityId                string `json:"EntityId"` //KyckEntity onboarded by a CORPORATE request, empty for individuals
+	InfoRequests            []InfoRequest `json:"InfoRequests"` //Filled on read, exchanges with the customer
+	Organisation            string `json:"Organisation"` //Organisation of the approver, scopes who can see the request
 }
 
+//==============================================================================================================================
+//	 PersonalDetails / KYCDetails - Shape of the PersonalDetails and KYCDetails of a brokerage request (stored as JSON
+//									in their columns) and of a user's personalDetails. Fields the schema does not know
+//									end up in Extensions.
+//==============================================================================================================================
+type PersonalDetails struct {
+	CustomerType         string    `json:"CustomerType"`         //INDIVIDUAL or CORPORATE
+	FirstName            string    `json:"FirstName"`
+	MiddleName           string    `json:"MiddleName"`
+	LastName             string    `json:"LastName"`
+	DateOfBirth          string    `json:"DateOfBirth"`          //YYYY-MM-DD
+	Nationality          string    `json:"Nationality"`          //ISO 3166-1 alpha-2
+	LegalName            string    `json:"LegalName"`            //Corporate customers
+	RegistrationNumber   string    `json:"RegistrationNumber"`
+	IncorporationCountry string    `json:"IncorporationCountry"` //ISO 3166-1 alpha-2
+	IncorporationDate    string    `json:"IncorporationDate"`    //YYYY-MM-DD
+	Addresses            []Address `json:"Addresses"`
+	Extensions           map[string]interface{} `json:"Extensions"`
+}
+
+type Address struct {
+	Type            string   `json:"Type"` //RESIDENTIAL, MAILING or REGISTERED
+	Line1           string   `json:"Line1"`
+	Line2           string   `json:"Line2"`
+	City            string   `json:"City"`
+	Region          string   `json:"Region"`
+	PostalCode      string   `json:"PostalCode"`
+	Country         string   `json:"Country"` //ISO 3166-1 alpha-2
+}
+
+type KYCDetails struct {
+	IdDocuments     []IdDocument   `json:"IdDocuments"`
+	TaxResidencies  []TaxResidency `json:"TaxResidencies"`
+	Occupation      string         `json:"Occupation"`
+	Employer        string         `json:"Employer"`
+	SourceOfFunds   string         `json:"SourceOfFunds"`
+	Extensions      map[string]interface{} `json:"Extensions"`
+}
+
+type IdDocument struct {
+	Type            string   `json:"Type"` //PASSPORT, ID_CARD, DRIVING_LICENCE, ...
+	Number          string   `json:"Number"`
+	IssuingCountry  string   `json:"IssuingCountry"` //ISO 3166-1 alpha-2
+	IssueDate       string   `json:"IssueDate"`      //YYYY-MM-DD
+	ExpiryDate      string   `json:"ExpiryDate"`     //YYYY-MM-DD
+}
+
+type TaxResidency struct {
+	Country         string   `json:"Country"` //ISO 3166-1 alpha-2
+	TIN             string   `json:"TIN"`
+}
+
+//==============================================================================================================================
+//	 KyckEntity - Legal entity onboarded by a corporate brokerage request. Directors, beneficial owners and
+//				  signatories are individuals with their own KyckUser package.
+//==============================================================================================================================
+type KyckEntity struct {
+	EntityId           string           `json:"EntityId"`
+	LegalName          string           `json:"LegalName"`
+	RegistrationNumber string           `json:"RegistrationNumber"`
+	Jurisdiction       string           `json:"Jurisdiction"` //ISO 3166-1 alpha-2
+	IncorporationDate  string           `json:"IncorporationDate"` //YYYY-MM-DD
+	RegisteredAddress  Address          `json:"RegisteredAddress"`
+	Directors          []RelatedParty   `json:"Directors"`
+	BeneficialOwners   []BeneficialOwner `json:"BeneficialOwners"`
+	Signatories        []RelatedParty   `json:"Signatories"`
+	CreatedBy          string           `json:"CreatedBy"`
+	UpdatedAt          string           `json:"UpdatedAt"`
+	Verified           bool             `json:"Verified"`
+	VerifiedBy         string           `json:"VerifiedBy"`
+	SourceRequestID    string           `json:"SourceRequestID"`
+}
+
+type RelatedParty struct {
+	UserId          string   `json:"UserId"` //KyckUser of the person
+	Name            string   `json:"Name"`
+	Title           string   `json:"Title"`
+}
+
+type BeneficialOwner struct {
+	UserId            string   `json:"UserId"` //KyckUser of the person
+	Name              string   `json:"Name"`
+	OwnershipPercent  float64  `json:"OwnershipPercent"` //Direct plus indirect
+}
+
+type UBOPolicy struct {
+	ThresholdPercent  float64  `json:"ThresholdPercent"` //Owners at or above this need a verified KYC package
+}
+
+/**** Entry of the check_entity_ubos result ****/
+type UBOCheck struct {
+	UserId            string   `json:"UserId"`
+	OwnershipPercent  float64  `json:"OwnershipPercent"`
+	Required          bool     `json:"Required"`
+	Verified          bool     `json:"Verified"`
+	Reason            string   `json:"Reason"`
+}
+
+//==============================================================================================================================
+//	 ApprovalPolicy - Approvals a brokerage request of one risk category needs before it can be APPROVED: one
+//					  approval per required role and Quorum approvals out of the named Reviewers.
+//==============================================================================================================================
+type ApprovalPolicy struct {
+	RiskCategory    string   `json:"RiskCategory"` //LOW, MEDIUM or HIGH
+	RequiredRoles   []string `json:"RequiredRoles"` //e.g. broker and compliance_officer
+	Reviewers       []string `json:"Reviewers"`
+	Quorum          int      `json:"Quorum"` //N of the len(Reviewers) reviewers, 0 when no quorum is needed
+}
+
+type ApprovalDecision struct {
+	RequestID       string   `json:"RequestID"`
+	Reviewer        string   `json:"Reviewer"`
+	Roles           []string `json:"Roles"` //Accessor roles of the reviewer at the time of the decision
+	Decision        string   `json:"Decision"` //APPROVE or REJECT
+	Comment         string   `json:"Comment"`
+	DecidedAt       string   `json:"DecidedAt"`
+}
+
+/**** Result of get_approval_status ****/
+type ApprovalStatus struct {
+	RequestID       string             `json:"RequestID"`
+	Policy          *ApprovalPolicy    `json:"Policy"` //nil when the approver alone decides
+	Decisions       []ApprovalDecision `json:"Decisions"`
+	Satisfied       bool               `json:"Satisfied"`
+	Missing         []string           `json:"Missing"`
+}
+
+/**** Current reviewer of a brokerage request, also written to its Approver column ****/
+type Assignment struct {
+	RequestID       string   `json:"RequestID"`
+	Reviewer        string   `json:"Reviewer"`
+	Organisation    string   `json:"Organisation"`
+	AssignedBy      string   `json:"AssignedBy"`
+	AssignedAt      string   `json:"AssignedAt"`
+	Reason          string   `json:"Reason"` //e.g. escalation, load balancing, claimed
+}
+
+type SLAPolicy struct {
+	MaxWaitHours    int      `json:"MaxWaitHours"` //Open requests older than this breach the SLA
+}
+
+/**** Entry of the get_review_queue and get_sla_breaches results ****/
+type QueueEntry struct {
+	RequestID       string   `json:"RequestID"`
+	Submitter       string   `json:"Submitter"`
+	Reviewer        string   `json:"Reviewer"`
+	Status          string   `json:"Status"`
+	SubmittedAt     string   `json:"SubmittedAt"`
+	AgeHours        int      `json:"AgeHours"`
+	SLABreached     bool     `json:"SLABreached"`
+	submitted       time.Time
+	age             time.Duration //Without the time spent waiting for the customer, see review_age
+}
+
+/**** Longest waiting first ****/
+type byAge []QueueEntry
+
+func (a byAge) Len() int      { return len(a) }
+func (a byAge) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAge) Less(i, j int) bool {
+	if a[i].age != a[j].age {
+		return a[i].age > a[j].age
+	}
+	return a[i].submitted.Before(a[j].submitted)
+}
+
+//==============================================================================================================================
+//	 InfoRequest - One request of the approver for more information and the customer's response to it
+//==============================================================================================================================
+type InfoRequest struct {
+	RequestID       string        `json:"RequestID"`
+	Sequence        int           `json:"Sequence"`
+	Items           []InfoItem    `json:"Items"`
+	Message         string        `json:"Message"`
+	DueDate         string        `json:"DueDate"` //YYYY-MM-DD
+	RequestedBy     string        `json:"RequestedBy"`
+	RequestedAt     string        `json:"RequestedAt"`
+	Response        *InfoResponse `json:"Response"` //nil while open
+}
+
+type InfoItem struct {
+	DocumentId      string   `json:"DocumentId"` //Document concerned, or
+	Field           string   `json:"Field"`      //field of PersonalDetails / KYCDetails concerned
+	Message         string   `json:"Message"`
+}
+
+type InfoResponse struct {
+	Message         string            `json:"Message"`
+	Documents       []DossierResource `json:"Documents"` //New documents, also added as resources of the customer
+	RespondedBy     string            `json:"RespondedBy"`
+	RespondedAt     string            `json:"RespondedAt"`
+	Late            bool              `json:"Late"` //Responded after the due date
+}
+
+//==============================================================================================================================
+//	 Notification - Intent to notify a customer or accessor, written to the outbox and delivered by an off-chain
+//					worker which acknowledges it afterwards
+//==============================================================================================================================
+type Notification struct {
+	NotificationId  string            `json:"NotificationId"` //Also the idempotency key for the delivery
+	Recipient       string            `json:"Recipient"`      //UserId or AccessorId
+	Channel         string            `json:"Channel"`        //EMAIL or SMS
+	Address         string            `json:"Address"`        //Current email address or phone number, filled by get_pending_notifications only
+	TemplateId      string            `json:"TemplateId"`
+	Parameters      map[string]string `json:"Parameters"`
+	CreatedAt       string            `json:"CreatedAt"`
+	Acknowledged    bool              `json:"Acknowledged"`
+	AcknowledgedBy  string            `json:"AcknowledgedBy"`
+	AcknowledgedAt  string            `json:"AcknowledgedAt"`
+	DeliveryRef     string            `json:"DeliveryRef"` //Reference of the delivery, e.g. the provider's message id
+}
+
+//==============================================================================================================================
+//	 ProfileChange - Change of identity-bearing user fields, applied only once a document verifier approves it
+//==============================================================================================================================
+type ProfileChange struct {
+	ChangeId        string                     `json:"ChangeId"`
+	UserId          string                     `json:"UserId"`
+	NewValues       map[string]json.RawMessage `json:"NewValues"` //User JSON field -> proposed value
+	OldValues       map[string]json.RawMessage `json:"OldValues"` //User JSON field -> value when the change was requested
+	Status          string                     `json:"Status"` //PENDING, APPROVED or REJECTED
+	RequestedBy     string                     `json:"RequestedBy"`
+	RequestedAt     string                     `json:"RequestedAt"`
+	ReviewedBy      string                     `json:"ReviewedBy"`
+	ReviewedAt      string                     `json:"ReviewedAt"`
+	Comment         string                     `json:"Comment"`
+}
+
+//==============================================================================================================================
+//	 ContactVerification - One-time code verification of a user's email address (EMAIL) or phone number (SMS). Only
+//						   the hash of the code is on the ledger, the code itself is sent off-chain.
+//==============================================================================================================================
+type ContactVerification struct {
+	UserId          string   `json:"UserId"`
+	Channel         string   `json:"Channel"` //EMAIL or SMS
+	Address         string   `json:"Address"` //Email address or phone number the code was sent to
+	CodeHash        string   `json:"CodeHash"` //HMAC-SHA256 hex of userId|channel|code keyed with CodeSalt
+	CodeSalt        string   `json:"CodeSalt"` //Random per-code key chosen by the notifier
+	ExpiresAt       string   `json:"ExpiresAt"`
+	Attempts        int      `json:"Attempts"`
+	IssuedBy        string   `json:"IssuedBy"`
+	IssuedAt        string   `json:"IssuedAt"`
+	Verified        bool     `json:"Verified"` //Only holds while Address is still the user's current address
+	VerifiedAt      string   `json:"VerifiedAt"`
+	LastResult      string   `json:"LastResult"` //Outcome of the last confirm_contact
+}
+
+//==============================================================================================================================
+//	 Organisation - Broker, agency or regulator that accessors belong to. Brokerage requests are scoped to the
+//					organisation of their approver.
+//==============================================================================================================================
+type Organisation struct {
+	OrgId           string   `json:"OrgId"`
+	Name            string   `json:"Name"`
+	Type            string   `json:"Type"` //BROKER, AGENCY or REGULATOR
+	CreatedAt       string   `json:"CreatedAt"`
+}
+
+/**** What the caller may see, see caller_scope ****/
+type CallerScope struct {
+	All             bool     //Admins and cross-org regulators
+	Org             string   //Organisation of the calling accessor
+	UserId          string
+	approverOrgs    map[string]string
+}
+
+//==============================================================================================================================
+//	 RestrictedPayload - PII of a brokerage request, kept out of the BrokerageRequests row, its history and the audit
+//						 log, which only hold its hashes. Stored per organisation under "private_<org>|<requestId>".
+//
+//	 This is access control on the chaincode's read functions, not private data: the v0.6 shim has no private
+//	 collections and no way to keep a key, so the record sits in the same world state as everything else and every
+//	 peer holds it, as it holds the transaction that brought the PII in. Whoever reads the world state directly reads
+//	 the PII. Keeping it off the peers needs private collections (Fabric 1.2+) or PII that never reaches chaincode.
+//==============================================================================================================================
+type RestrictedPayload struct {
+	RequestID       string   `json:"RequestID"`
+	Organisation    string   `json:"Organisation"`
+	Documents       string   `json:"Documents"`
+	PersonalDetails string   `json:"PersonalDetails"`
+	KYCDetails      string   `json:"KYCDetails"`
+	Salt            string   `json:"Salt"` //Key of the shared hashes, see sealed_value
+}
+
+//==============================================================================================================================
+//	 RestrictedRecord - PII of a KYC package, a user's personal details or a profile change, kept out of the record
+//						readers get by default like a RestrictedPayload, and in the same world state. Stored under
+//						"privatekyc_<userId>", "privateuser_<userId>" and "privatechange_<userId>|<changeId>", the
+//						record itself holds the hash of each field.
+//==============================================================================================================================
+type RestrictedRecord struct {
+	Salt            string            `json:"Salt"`
+	Values          map[string]string `json:"Values"` //Field -> plain value
+}
+
+//==============================================================================================================================
+//	 Attestation - Claim about one attribute of a verified KYC package, e.g. "over 18" or "resident in the EU". The
+//				   chaincode evaluates the claim against the package itself, so a verifier learns whether the claim
+//				   holds and nothing about the underlying fields. Nothing is signed: chaincode holds no key. The
+//				   Digest is an unkeyed hash of public fields, anyone can compute it, it only ties a copy handed out
+//				   off-chain to the ledger record. What a verifier can rely on is verify_attestation, which reads
+//				   that record and the KYC package behind it.
+//==============================================================================================================================
+type Attestation struct {
+	AttestationId   string   `json:"AttestationId"`
+	Subject         string   `json:"Subject"`   //UserId of the customer
+	ClaimType       string   `json:"ClaimType"` //AGE_OVER, RESIDENT_IN or KYC_LEVEL
+	ClaimValue      string   `json:"ClaimValue"` //e.g. 18, EU or DE, STANDARD or ENHANCED
+	SourceRequestID string   `json:"SourceRequestID"` //Request the KYC package was verified on
+	IssuedAt        string   `json:"IssuedAt"`
+	ExpiresAt       string   `json:"ExpiresAt"` //YYYY-MM-DD, the KYC expiry date
+	Digest          string   `json:"Digest"`    //Unkeyed SHA-256 of the fields above, not a signature
+	Revoked         bool     `json:"Revoked"`
+}
+
+/**** Result of verify_attestation ****/
+type AttestationVerification struct {
+	AttestationId   string   `json:"AttestationId"`
+	ClaimType       string   `json:"ClaimType"`
+	ClaimValue      string   `json:"ClaimValue"`
+	Valid           bool     `json:"Valid"`
+	Reason          string   `json:"Reason"` //Why it is not valid
+}
+
+/**** A withdrawn or archived brokerage request keeps its row, its payload is purged after the retention period ****/
+type RequestClosure struct {
+	State           string   `json:"State"` //WITHDRAWN or ARCHIVED
+	Reason          string   `json:"Reason"`
+	ClosedBy        string   `json:"ClosedBy"`
+	ClosedAt        string   `json:"ClosedAt"`
+	PurgeAfter      string   `json:"PurgeAfter"` //YYYY-MM-DD
+	Purged          bool     `json:"Purged"`
+	PurgedAt        string   `json:"PurgedAt"`
+}
+
+type RetentionPolicy struct {
+	RetentionDays   int      `json:"RetentionDays"` //Days after closure before the payload columns are purged
+}
+
+/**** Stored under "kyckuser_<userId>" with its PII sealed, see put_kyck_user ****/
 type KyckUser struct {
 	UserId       string   `json:"userId"` //Same username as on certificate in CA
 	FirstName    string   `json:"firstName"`
@@ -67,7 +421,37 @@ type KyckUser struct {
 	TimeStamp   			string
 	userType				string
 	Rights					[]byte
+	Verified				bool	`json:"Verified"`
+	VerifiedBy				string	`json:"VerifiedBy"`		//Approver of the source request
+	SourceRequestID			string	`json:"SourceRequestID"`	//Brokerage request the package was verified on
+	DocumentExpiries		[]DocumentExpiry	`json:"DocumentExpiries"`
+	RiskCategory			string	`json:"RiskCategory"`		//LOW, MEDIUM or HIGH, picks the review interval
+	ReviewIntervalMonths	int		`json:"ReviewIntervalMonths"`
+	KYCExpiryDate			string	`json:"KYCExpiryDate"`		//YYYY-MM-DD, re-KYC is due on this date
+	RiskScore				int		`json:"RiskScore"`
+	RiskAssessment			*RiskAssessment	`json:"RiskAssessment"`
+	EDDApprovedBy			string	`json:"EDDApprovedBy"`		//Compliance officer who approved the enhanced due diligence
+}
+
+type DocumentExpiry struct {
+	DocumentId      string   `json:"DocumentId"`
+	DocumentType    string   `json:"DocumentType"`
+	ExpiryDate      string   `json:"ExpiryDate"` //YYYY-MM-DD
+}
+
+/**** Entry of the get_kyc_due_for_refresh result ****/
+type KYCRefreshDue struct {
+	UserId          string   `json:"UserId"`
+	RiskCategory    string   `json:"RiskCategory"`
+	KYCExpiryDate   string   `json:"KYCExpiryDate"`
+	DaysLeft        int      `json:"DaysLeft"` //Negative once expired
 }
+
+type byExpiryDate []KYCRefreshDue
+
+func (a byExpiryDate) Len() int           { return len(a) }
+func (a byExpiryDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byExpiryDate) Less(i, j int) bool { return a[i].KYCExpiryDate < a[j].KYCExpiryDate }
 /**** Accessor can be Broker, Govt agency, Regulator, etc ****/
 type KyckAccessor struct {
 	AccessorId      string   `json:"AccessorId"` //Same username as on certificate in CA
@@ -75,6 +459,8 @@ type KyckAccessor struct {
 	Address  		string   `json:"Address"`
 	Email    		[]string `json:"Email"`
 	Phone     		string   `json:"Phone"`
+	Roles    		[]string `json:"Roles"` //e.g. doc_verifier
+	Organisation	string   `json:"Organisation"` //Requests are assigned and reassigned within one organisation
 	userType		string
 }
 
@@ -84,37 +470,131 @@ type BrokerageResponse struct {
 }
 
 type BrokerageRequestTimeStamp struct {
-	Submit 					string 
-	MeetingConfirmation		string
+	Submit 					string 	//Set once on creation, the review SLA counts from it
+	MeetingConfirmation		string	//Last MEETING update
 	FinalStatus				string
 
 }
+
+/**** Result of one document check, attached by a registered document-verification accessor ****/
+type DocumentCheck struct {
+	DocumentId      string   `json:"DocumentId"`
+	DocumentType    string   `json:"DocumentType"`   //PASSPORT, ID_CARD, DRIVING_LICENCE, ...
+	IssuingCountry  string   `json:"IssuingCountry"` //ISO 3166-1 alpha-2
+	ExpiryDate      string   `json:"ExpiryDate"`     //YYYY-MM-DD
+	MRZVerdict      string   `json:"MRZVerdict"`     //PASS, FAIL or NOT_APPLICABLE
+	TamperFlags     []string `json:"TamperFlags"`
+	Verdict         string   `json:"Verdict"`        //Computed on-chain
+	CheckedBy       string   `json:"CheckedBy"`
+	CheckedAt       string   `json:"CheckedAt"`
+}
+
+/**** Stored in the DocValidationReport column of a brokerage request ****/
+type DocumentValidationReport struct {
+	RequestID       string          `json:"RequestID"`
+	Checks          []DocumentCheck `json:"Checks"`
+	Verdict         string          `json:"Verdict"` //Aggregate of the per-document verdicts
+	UpdatedAt       string          `json:"UpdatedAt"`
+}
+
+/**** Customer's consent to share their verified KYC package with one accessor ****/
+type KYCConsent struct {
+	UserId          string   `json:"UserId"`
+	AccessorId      string   `json:"AccessorId"`
+	RequestID       string   `json:"RequestID"` //Brokerage request created when the consent was given
+	Active          bool     `json:"Active"`
+	GrantedAt       string   `json:"GrantedAt"`
+	RevokedAt       string   `json:"RevokedAt"`
+}
+
+/**** Configurable customer risk model, points per factor are added up into a score ****/
+type RiskModel struct {
+	Version          int              `json:"Version"` //Bumped by set_risk_model
+	CountryPoints    map[string]int   `json:"CountryPoints"`    //ISO country code -> points
+	OccupationPoints map[string]int   `json:"OccupationPoints"`
+	ProductPoints    map[string]int   `json:"ProductPoints"`
+	PEPPoints        int              `json:"PEPPoints"`
+	VolumeBands      []VolumeBand     `json:"VolumeBands"`
+	DefaultPoints    int              `json:"DefaultPoints"`    //For a country, occupation or product not in the maps
+	MediumThreshold  int              `json:"MediumThreshold"`  //Score from which the category is MEDIUM
+	HighThreshold    int              `json:"HighThreshold"`    //Score from which the category is HIGH
+}
+
+/**** Expected yearly transaction volume from Min up to, not including, Max. Max 0 means no upper bound ****/
+type VolumeBand struct {
+	Min             int64    `json:"Min"`
+	Max             int64    `json:"Max"`
+	Points          int      `json:"Points"`
+}
+
+type RiskInputs struct {
+	Country           string   `json:"Country"`    //Riskiest country named in the request
+	Occupation        string   `json:"Occupation"` //From the KYCDetails when given there
+	PEP               bool     `json:"PEP"`        //From the latest screening
+	ProductType       string   `json:"ProductType"`
+	TransactionVolume int64    `json:"TransactionVolume"` //Expected per year
+}
+
+type RiskAssessment struct {
+	RequestID       string         `json:"RequestID"`
+	Inputs          RiskInputs     `json:"Inputs"`
+	Points          map[string]int `json:"Points"` //Points per factor, adds up to Score
+	Score           int            `json:"Score"`
+	Category        string         `json:"Category"`
+	EDDRequired     bool           `json:"EDDRequired"` //Enhanced due diligence
+	ModelVersion    int            `json:"ModelVersion"`
+	AssessedBy      string         `json:"AssessedBy"`
+	AssessedAt      string         `json:"AssessedAt"`
+}
+
+/**** Sanctions/PEP screening of one customer by a screening provider ****/
+type ScreeningResult struct {
+	ScreeningId        string           `json:"ScreeningId"` //Transaction ID of record_screening
+	UserId             string           `json:"UserId"`
+	Provider           string           `json:"Provider"`
+	ListsChecked       []ScreeningList  `json:"ListsChecked"`
+	Matches            []ScreeningMatch `json:"Matches"`
+	ScreenedAt         string           `json:"ScreenedAt"`
+	Disposition        string           `json:"Disposition"`
+	DispositionBy      string           `json:"DispositionBy"`
+	DispositionAt      string           `json:"DispositionAt"`
+	DispositionComment string           `json:"DispositionComment"`
+}
+
+type ScreeningList struct {
+	Name            string   `json:"Name"`    //e.g. OFAC SDN, EU Consolidated, PEP
+	Version         string   `json:"Version"`
+}
+
+type ScreeningMatch struct {
+	List            string   `json:"List"`
+	EntryId         string   `json:"EntryId"`
+	MatchedName     string   `json:"MatchedName"`
+	Score           int      `json:"Score"` //Provider's match strength, 0-100
+}
+
 type Thing struct {
-	Id          string `json:"id"`
-	Description string `json:"description"`
+	Id          string            `json:"id"`
+	Description string            `json:"description"`
+	Owner       string            `json:"owner"` //User ID, the thing is in that user's Things
+	Attributes  map[string]string `json:"attributes"`
+	CreatedBy   string            `json:"createdBy"`
+	CreatedAt   string            `json:"createdAt"`
+	UpdatedBy   string            `json:"updatedBy"`
+	UpdatedAt   string            `json:"updatedAt"`
 }
 
 //=================================================================================================================================
-//  Index collections - In order to create new IDs dynamically and in progressive sorting
+//  Index collections - One key per entry, "<index>_<id>" with the id as value, enumerated with a range scan so an
+//  insert only writes its own key and concurrent inserts don't conflict.
 //  Example:
-//    signaturesAsBytes, err := stub.GetState(signaturesIndexStr)
-//    if err != nil { return nil, errors.New("Failed to get Signatures Index") }
-//    fmt.Println("Signature index retrieved")
-//
-//    // Unmarshal the signatures index
-//    var signaturesIndex []string
-//    json.Unmarshal(signaturesAsBytes, &signaturesIndex)
-//    fmt.Println("Signature index unmarshalled")
-//
-//    // Create new id for the signature
-//    var newSignatureId string
-//    newSignatureId = "sg" + strconv.Itoa(len(signaturesIndex) + 1)
+//    // Create new id for the signature and add it to the index
+//    newSignatureId, err := append_id(stub, signaturesIndexStr, "sg", true)
+//    if err != nil { return nil, err }
 //
-//    // append the new signature to the index
-//    signaturesIndex = append(signaturesIndex, newSignatureId)
-//    jsonAsBytes, _ := json.Marshal(signaturesIndex)
-//    err = stub.PutState(signaturesIndexStr, jsonAsBytes)
-//    if err != nil { return nil, errors.New("Error storing new signaturesIndex into ledger") }
+//    // All signatures, in key order
+//    signatureIds, err := get_index_ids(stub, signaturesIndexStr)
+//    if err != nil { return nil, err }
 //=================================================================================================================================
 var usersIndexStr = "_users"
 var thingsIndexStr = "_things"
@@ -122,6 +602,320 @@ var applicationIndexStr ="_applications"
 
 var indexes = []string{usersIndexStr, thingsIndexStr,applicationIndexStr}
 
+func is_index(indexStr string) bool {
+	for _, i := range indexes {
+		if i == indexStr {
+			return true
+		}
+	}
+	return false
+}
+
+/**** Query of the listing functions (query_things, query_users, query_brokerage_requests) ****/
+type ListingQuery struct {
+	Selector        map[string]interface{} `json:"selector"` //field -> value, or field -> {"$gt": v, "$prefix": "ab", ...}
+	Fields          []string               `json:"fields"`   //Projection, all fields when empty
+	Limit           int                    `json:"limit"`
+	Bookmark        string                 `json:"bookmark"` //From the previous page
+	IncludeClosed   bool                   `json:"includeClosed"` //Brokerage requests only, regulators only
+}
+
+type ListingPage struct {
+	Records         []map[string]interface{} `json:"records"`
+	Count           int                      `json:"count"`
+	Bookmark        string                   `json:"bookmark"` //Empty on the last page
+}
+
+const defaultPageSize = 25
+const maxPageSize = 200
+
+/**** Bulk import ****/
+const maxBatchSize = 100
+const batchAllOrNothing = "all_or_nothing"	//Any invalid item fails the whole transaction
+const batchPartial = "partial"				//Valid items are written, invalid ones reported
+
+type BatchResult struct {
+	Mode            string         `json:"Mode"`
+	Total           int            `json:"Total"`
+	Succeeded       []string       `json:"Succeeded"` //IDs written
+	Failures        []BatchFailure `json:"Failures"`
+}
+
+type BatchFailure struct {
+	Index           int      `json:"Index"` //Position in the batch
+	Id              string   `json:"Id"`
+	Error           string   `json:"Error"`
+}
+
+/**** Everything held about one customer, see get_kyc_dossier. A hash-anchored export: nothing is signed, a copy is
+      checked against the PackageHash that record_dossier_export kept on the ledger ****/
+type KYCDossier struct {
+	UserId          string            `json:"UserId"`
+	GeneratedAt     string            `json:"GeneratedAt"` //Not covered by the manifest
+	Sections        map[string]json.RawMessage `json:"Sections"`
+	Manifest        map[string]string `json:"Manifest"`    //Section -> SHA-256 of its canonical JSON
+	PackageHash     string            `json:"PackageHash"` //SHA-256 of the canonical manifest
+}
+
+type DossierResource struct {
+	Hash            string   `json:"Hash"`
+	Path            string   `json:"Path"`
+}
+
+/**** Kept on the ledger by record_dossier_export so a package can be checked later ****/
+type DossierExport struct {
+	UserId          string            `json:"UserId"`
+	PackageHash     string            `json:"PackageHash"`
+	Manifest        map[string]string `json:"Manifest"`
+	ExportedBy      string            `json:"ExportedBy"`
+	ExportedAt      string            `json:"ExportedAt"`
+}
+
+type DossierVerification struct {
+	Intact          bool     `json:"Intact"`   //Sections match the manifest and the manifest matches the hash
+	Recorded        bool     `json:"Recorded"` //The hash was recorded by record_dossier_export
+	ExportedAt      string   `json:"ExportedAt"`
+	Mismatches      []string `json:"Mismatches"`
+}
+
+/**** Difference between an index and the records that actually exist ****/
+type IndexDriftReport struct {
+	Index           string   `json:"Index"`
+	DryRun          bool     `json:"DryRun"`
+	Entries         int      `json:"Entries"`  //Entries in the index
+	Records         int      `json:"Records"`  //Records found
+	Missing         []string `json:"Missing"`  //Records without an entry
+	Orphaned        []string `json:"Orphaned"` //Entries without a record
+}
+
+//==============================================================================================================================
+//	 Audit log - One key per entry, "audit_<subject>|<time>|<txid>", so the entries of a subject are one range scan
+//==============================================================================================================================
+var auditPrefix = "audit_"
+
+type AuditEntry struct {
+	TxID            string   `json:"TxID"`
+	Timestamp       string   `json:"Timestamp"`
+	Actor           string   `json:"Actor"`
+	Role            string   `json:"Role"`
+	Action          string   `json:"Action"`
+	Subject         string   `json:"Subject"`
+	Details         string   `json:"Details"` //JSON
+}
+
+//==============================================================================================================================
+//	 Roles - "admin" is read from the caller's ECert, the others are granted to registered KyckAccessors
+//==============================================================================================================================
+const roleAdmin = "admin"
+const roleDocVerifier = "doc_verifier"
+const roleBroker = "broker"
+const roleOperations = "operations"
+const roleNotifier = "notifier"		//Off-chain worker delivering the outbox
+
+var accessorPrefix = "accessor_"
+var kyckUserPrefix = "kyckuser_"
+var consentPrefix = "consent_"
+var resourcePrefix = "_resources_"
+var dossierPrefix = "dossier_"
+var userRequestPrefix = "userrequest_"		//"<userId>|<requestId>", the requests a customer submitted
+var userScreeningPrefix = "userscreening_"	//"<userId>|<screeningId>", every screening of a customer
+var entityPrefix = "kyckentity_"
+
+var approvalPolicyPrefix = "approvalpolicy_"
+var approvalDecisionPrefix = "approval_"
+
+const decisionApprove = "APPROVE"
+const decisionReject = "REJECT"
+
+var assignmentPrefix = "assignment_"
+var infoRequestPrefix = "inforequest_"
+var outboxPrefix = "outbox_"			//Pending notifications, in creation order
+var outboxDonePrefix = "outboxdone_"	//Acknowledged notifications
+
+var profileChangePrefix = "profilechange_"
+
+/**** User JSON fields a profile update may touch, the sensitive ones go through a ProfileChange ****/
+var immediateProfileFields = []string{"phoneNumber", "emailAddress"}
+var sensitiveProfileFields = []string{"firstName", "lastName", "address", "personalDetails"}
+
+const changePending = "PENDING"
+const changeApproved = "APPROVED"
+const changeRejected = "REJECTED"
+
+const channelEmail = "EMAIL"
+const channelSMS = "SMS"
+
+var contactVerificationPrefix = "contactverification_"
+
+const maxCodeAttempts = 5
+const maxCodeLifetime = 24 * time.Hour
+const minCodeLength = 10		//Codes are drawn at random off-chain, 10 alphanumerics is about 59 bits
+const minSaltLength = 32
+
+const codeConfirmed = "CONFIRMED"
+const codeInvalid = "INVALID_CODE"
+const codeExpired = "EXPIRED"
+const codeLocked = "TOO_MANY_ATTEMPTS"
+var slaPolicyStr = "_sla_policy"
+var defaultSLAPolicy = SLAPolicy{MaxWaitHours: 72}
+
+var uboPolicyStr = "_ubo_policy"
+var defaultUBOPolicy = UBOPolicy{ThresholdPercent: 25}
+
+//==============================================================================================================================
+//	 Periodic re-KYC - months between reviews per risk category, overridable with set_review_policy
+//==============================================================================================================================
+const riskLow = "LOW"
+const riskMedium = "MEDIUM"
+const riskHigh = "HIGH"
+
+var reviewPolicyStr = "_review_policy"
+var riskModelStr = "_risk_model"
+var riskPrefix = "risk_"
+var defaultReviewPolicy = map[string]int{riskLow: 36, riskMedium: 24, riskHigh: 12}
+
+const dateLayout = "2006-01-02"
+
+//==============================================================================================================================
+//	 Brokerage request status
+//==============================================================================================================================
+const statusSubmitted = "SUBMITTED"
+const statusInReview = "IN_REVIEW"
+const statusApproved = "APPROVED"
+const statusRejected = "REJECTED"
+const statusWithdrawn = "WITHDRAWN"
+const statusInfoRequested = "INFO_REQUESTED"	//Waiting for the customer, see request_information
+
+const closureWithdrawn = "WITHDRAWN"
+const closureArchived = "ARCHIVED"
+
+const roleRegulator = "regulator"
+const roleCrossOrgRegulator = "cross_org_regulator"	//Sees the records of every organisation
+
+var orgPrefix = "org_"
+var customerPrefix = "customer_"	//"<org>|<userId>|<requestId>", the customers an organisation handles, see query_users
+var attestationPrefix = "attestation_"
+var presentationPrefix = "presentation_"
+
+const claimAgeOver = "AGE_OVER"
+const claimResidentIn = "RESIDENT_IN"
+const claimKYCLevel = "KYC_LEVEL"
+
+const kycLevelStandard = "STANDARD"
+const kycLevelEnhanced = "ENHANCED"	//Enhanced due diligence was done
+
+var euCountries = map[string]bool{"AT": true, "BE": true, "BG": true, "HR": true, "CY": true, "CZ": true, "DK": true,
+	"EE": true, "FI": true, "FR": true, "DE": true, "GR": true, "HU": true, "IE": true, "IT": true, "LV": true, "LT": true,
+	"LU": true, "MT": true, "NL": true, "PL": true, "PT": true, "RO": true, "SK": true, "SI": true, "ES": true, "SE": true}
+
+var privatePrefix = "private_"
+var sealedPrefix = "hmac-sha256:"	//Value of a field moved to a restricted record, keyed with the record's salt
+var legacySealedPrefix = "sha256:"	//Unsalted, written before the salt, see seal_legacy_payloads
+var privateKYCPrefix = "privatekyc_"
+var privateUserPrefix = "privateuser_"
+var privateChangePrefix = "privatechange_"
+
+var retentionPolicyStr = "_retention_policy"
+var defaultRetentionPolicy = RetentionPolicy{RetentionDays: 5 * 365}
+
+/**** Allowed status changes, the guards on top of these are in check_status_transition ****/
+var statusTransitions = map[string][]string{
+	statusSubmitted: []string{statusInReview, statusApproved, statusRejected},
+	statusInReview:  []string{statusApproved, statusRejected},
+	statusInfoRequested: []string{statusInReview, statusRejected},
+}
+
+func status_move_allowed(current string, newStatus string) bool {
+	return contains_string(statusTransitions[current], newStatus)
+}
+
+func is_open_status(status string) bool {
+	return status == "" || status == statusSubmitted || status == statusInReview || status == statusInfoRequested
+}
+
+//==============================================================================================================================
+//	 Sanctions / PEP screening
+//==============================================================================================================================
+const roleScreeningProvider = "screening_provider"
+const roleComplianceOfficer = "compliance_officer"
+
+const screeningNoMatch = "NO_MATCH"				//Nothing found, counts as cleared
+const screeningPendingReview = "PENDING_REVIEW"	//Matches found, waiting for a compliance officer
+const screeningCleared = "CLEARED"				//Matches dismissed as false positives
+const screeningTrueMatch = "TRUE_MATCH"
+
+var screeningPrefix = "screening_"
+var latestScreeningPrefix = "screeninguser_"
+
+//==============================================================================================================================
+//	 Reserved keyspaces - Users, things and resources are stored under their bare id, so an id starting with one of the
+//	 chaincode's own prefixes would overwrite e.g. an accessor record and hand its writer a role. Such ids are refused.
+//	 The shim stores the rows of the BrokerageRequests table under "<length of the name><name>".
+//==============================================================================================================================
+var reservedPrefixes = []string{"_", historyPrefix + "_", auditPrefix, accessorPrefix, kyckUserPrefix, consentPrefix,
+	dossierPrefix, userRequestPrefix, userScreeningPrefix, entityPrefix, approvalPolicyPrefix, approvalDecisionPrefix, assignmentPrefix, infoRequestPrefix,
+	outboxPrefix, outboxDonePrefix, profileChangePrefix, contactVerificationPrefix, riskPrefix, orgPrefix,
+	customerPrefix, attestationPrefix, presentationPrefix, privatePrefix, privateKYCPrefix, privateUserPrefix,
+	privateChangePrefix, screeningPrefix, latestScreeningPrefix,
+	strconv.Itoa(len("BrokerageRequests")) + "BrokerageRequests"}
+
+func is_reserved_key(key string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func check_record_id(id string) error {
+	if id == "" {
+		return errors.New("Id is required")
+	}
+	if is_reserved_key(id) {
+		return errors.New("Id " + id + " is in a reserved keyspace")
+	}
+	/**** Separates the parts of composite keys, e.g. customer_<org>|<userId>|<requestId> ****/
+	if strings.Contains(id, "|") {
+		return errors.New("Id " + id + " must not contain |")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 Document check verdicts
+//==============================================================================================================================
+const verdictPass = "PASS"
+const verdictFail = "FAIL"
+const verdictRefer = "REFER"		//Needs a human look, e.g. MRZ could not be read
+const verdictPending = "PENDING"	//No documents checked yet
+const verdictNotApplicable = "NOT_APPLICABLE"
+
+//==============================================================================================================================
+//	 Customer types and ISO 3166-1 alpha-2 country codes, see validate_personal_details
+//==============================================================================================================================
+const customerIndividual = "INDIVIDUAL"
+const customerCorporate = "CORPORATE"
+
+const addressResidential = "RESIDENTIAL"
+const addressMailing = "MAILING"
+const addressRegistered = "REGISTERED"
+
+var countryCodes = map[string]bool{}
+
+func init() {
+	for _, code := range strings.Fields(`
+		AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY
+		BZ CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK
+		FM FO FR GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR
+		IS IT JE JM JO JP KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK
+		ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM
+		PN PR PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF
+		TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW`) {
+		countryCodes[code] = true
+	}
+}
+
 //==============================================================================================================================
 //	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Passes the
 //  		 initial arguments passed are passed on to the called function.
@@ -132,19 +926,107 @@ func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args
 
 	if function == "init" {
 		return t.Init(stub, "init", args)
-	} else if function == "reset_indexes" {
-		return t.reset_indexes(stub, args)
+	} else if function == "rebuild_index" {
+		return t.rebuild_index(stub, args)
+	} else if function == "migrate_indexes" {
+		return t.migrate_indexes(stub, args)
 	} else if function == "add_user" {
 		return t.add_user(stub, args)
+	} else if function == "update_user" {
+		return t.update_user(stub, args)
 	} else if function == "add_thing" {
 		return t.add_thing(stub, args)
+	} else if function == "update_thing" {
+		return t.update_thing(stub, args)
+	} else if function == "transfer_thing" {
+		return t.transfer_thing(stub, args)
 	}else if function == "add_resource"{
         return t.add_resource(stub, args)
     }else if function == "create_brokerage_request" {	//Create a new application
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		}
 		return t.create_brokerage_request(stub, args[0])
 	}else if function == "update_brokerage_application" {
 		//updateType, jsonData, brokerageRequestId - input arguments
+		if len(args) != 1 {
+			return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		}
 		return t.update_brokerage_application(stub, args[0])
+	}else if function == "register_accessor" {
+		return t.register_accessor(stub, args)
+	}else if function == "add_document_check" {
+		return t.add_document_check(stub, args)
+	}else if function == "store_kyc_package" {
+		return t.store_kyc_package(stub, args)
+	}else if function == "share_kyc_with_broker" {
+		return t.share_kyc_with_broker(stub, args)
+	}else if function == "revoke_kyc_consent" {
+		return t.revoke_kyc_consent(stub, args)
+	}else if function == "set_review_policy" {
+		return t.set_review_policy(stub, args)
+	}else if function == "set_risk_model" {
+		return t.set_risk_model(stub, args)
+	}else if function == "assess_risk" {
+		return t.assess_risk(stub, args)
+	}else if function == "record_screening" {
+		return t.record_screening(stub, args)
+	}else if function == "disposition_screening" {
+		return t.disposition_screening(stub, args)
+	}else if function == "withdraw_brokerage_request" {
+		return t.close_brokerage_request(stub, args, closureWithdrawn)
+	}else if function == "archive_brokerage_request" {
+		return t.close_brokerage_request(stub, args, closureArchived)
+	}else if function == "set_retention_policy" {
+		return t.set_retention_policy(stub, args)
+	}else if function == "purge_expired_requests" {
+		return t.purge_expired_requests(stub, args)
+	}else if function == "import_users" {
+		return t.import_users(stub, args)
+	}else if function == "import_brokerage_requests" {
+		return t.import_brokerage_requests(stub, args)
+	}else if function == "record_dossier_export" {
+		return t.record_dossier_export(stub, args)
+	}else if function == "register_entity" {
+		return t.save_entity(stub, args, true)
+	}else if function == "update_entity" {
+		return t.save_entity(stub, args, false)
+	}else if function == "set_ubo_policy" {
+		return t.set_ubo_policy(stub, args)
+	}else if function == "set_approval_policy" {
+		return t.set_approval_policy(stub, args)
+	}else if function == "record_approval_decision" {
+		return t.record_approval_decision(stub, args)
+	}else if function == "assign_brokerage_request" {
+		return t.assign_brokerage_request(stub, args)
+	}else if function == "claim_brokerage_request" {
+		return t.claim_brokerage_request(stub, args)
+	}else if function == "set_sla_policy" {
+		return t.set_sla_policy(stub, args)
+	}else if function == "request_information" {
+		return t.request_information(stub, args)
+	}else if function == "respond_to_information_request" {
+		return t.respond_to_information_request(stub, args)
+	}else if function == "acknowledge_notification" {
+		return t.acknowledge_notification(stub, args)
+	}else if function == "update_profile" {
+		return t.update_profile(stub, args)
+	}else if function == "review_profile_change" {
+		return t.review_profile_change(stub, args)
+	}else if function == "issue_contact_code" {
+		return t.issue_contact_code(stub, args)
+	}else if function == "confirm_contact" {
+		return t.confirm_contact(stub, args)
+	}else if function == "register_organisation" {
+		return t.register_organisation(stub, args)
+	}else if function == "seal_legacy_payloads" {
+		return t.seal_legacy_payloads(stub, args)
+	}else if function == "issue_attestation" {
+		return t.issue_attestation(stub, args)
+	}else if function == "present_attestation" {
+		return t.present_attestation(stub, args)
+	}else if function == "revoke_attestation" {
+		return t.revoke_attestation(stub, args)
 	}
 
 	return nil, errors.New("Received unknown invoke function name")
@@ -158,19 +1040,74 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 	logger.Infof("Query is running " + function)
 
 	if function == "get_user" {
+		if len(args) != 2 {
+			return nil, errors.New("Incorrect number of arguments. Expecting 2")
+		}
 		return t.get_user(stub, args[1])
 	} else if function == "get_thing" {
 		return t.get_thing(stub, args)
 	} else if function == "get_all_things" {
 		return t.get_all_things(stub, args)
+	} else if function == "get_things_by_owner" {
+		return t.get_things_by_owner(stub, args)
+	} else if function == "query_things" {
+		return t.query_things(stub, args)
+	} else if function == "query_users" {
+		return t.query_users(stub, args)
+	} else if function == "query_brokerage_requests" {
+		return t.query_brokerage_requests(stub, args)
+	} else if function == "get_history" {
+		return t.get_history(stub, args)
+	} else if function == "get_kyc_dossier" {
+		return t.get_kyc_dossier(stub, args)
+	} else if function == "verify_kyc_dossier" {
+		return t.verify_kyc_dossier(stub, args)
+	} else if function == "get_version" {
+		return t.get_record_version(stub, args)
+	} else if function == "verify_indexes" {
+		return t.verify_indexes(stub, args)
+	} else if function == "get_audit_log" {
+		return t.get_audit_log(stub, args)
 	} else if function == "authenticate" {
 		return t.authenticate(stub, args)
 	}else if function == "get_resource"{
         return t.get_resource(stub, args)
     }else if function == "get_brokerage_request"{
-        return t.get_brokerage_request(stub, args[0])
+        return t.get_brokerage_request(stub, args)
     }else if function == "get_all_brokerage_requests"{
-        return t.get_all_brokerage_requests(stub, args[0])
+        return t.get_all_brokerage_requests(stub, args)
+    }else if function == "get_accessor"{
+        return t.get_accessor(stub, args)
+    }else if function == "get_shared_kyc"{
+        return t.get_shared_kyc(stub, args)
+    }else if function == "get_kyc_due_for_refresh"{
+        return t.get_kyc_due_for_refresh(stub, args)
+    }else if function == "get_risk_model"{
+        return stub.GetState(riskModelStr)
+    }else if function == "get_screening"{
+        return t.get_screening(stub, args)
+    }else if function == "get_latest_screening"{
+        return t.get_latest_screening(stub, args)
+    }else if function == "get_entity"{
+        return t.get_entity(stub, args)
+    }else if function == "check_entity_ubos"{
+        return t.check_entity_ubos(stub, args)
+    }else if function == "get_approval_status"{
+        return t.get_approval_status(stub, args)
+    }else if function == "get_review_queue"{
+        return t.get_review_queue(stub, args)
+    }else if function == "get_sla_breaches"{
+        return t.get_sla_breaches(stub, args)
+    }else if function == "get_pending_notifications"{
+        return t.get_pending_notifications(stub, args)
+    }else if function == "get_profile_changes"{
+        return t.get_profile_changes(stub, args)
+    }else if function == "get_contact_verification"{
+        return t.get_contact_verification(stub, args)
+    }else if function == "get_organisation"{
+        return t.get_organisation(stub, args)
+    }else if function == "verify_attestation"{
+        return t.verify_attestation(stub, args)
     }
 
 	return nil, errors.New("Received unknown query function name")
@@ -199,9 +1136,26 @@ func main() {
 //==============================================================================================================================
 
 func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	var empty []string
-	jsonAsBytes, _ := json.Marshal(empty)								//marshal an empty array of strings to clear the index
-	var err = stub.PutState(applicationIndexStr, jsonAsBytes)
+	policyAsBytes, _ := json.Marshal(defaultReviewPolicy)
+	var err = stub.PutState(reviewPolicyStr, policyAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	retentionAsBytes, _ := json.Marshal(defaultRetentionPolicy)
+	err = stub.PutState(retentionPolicyStr, retentionAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	slaAsBytes, _ := json.Marshal(defaultSLAPolicy)
+	err = stub.PutState(slaPolicyStr, slaAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	uboAsBytes, _ := json.Marshal(defaultUBOPolicy)
+	err = stub.PutState(uboPolicyStr, uboAsBytes)
 	if err != nil {
 		return nil, err
 	}
@@ -220,6 +1174,10 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 			&shim.ColumnDefinition{Name: "VideoRecording"		, Type:shim.ColumnDefinition_BYTES, 	Key:false},
 			&shim.ColumnDefinition{Name: "TimeStamps"		    , Type:shim.ColumnDefinition_BYTES, 	Key:false},
 			&shim.ColumnDefinition{Name: "Meeting"		        , Type:shim.ColumnDefinition_STRING, 	Key:false},
+			&shim.ColumnDefinition{Name: "KYCPackageRef"		, Type:shim.ColumnDefinition_STRING, 	Key:false},
+			&shim.ColumnDefinition{Name: "Closure"				, Type:shim.ColumnDefinition_BYTES, 	Key:false},
+			&shim.ColumnDefinition{Name: "EntityId"				, Type:shim.ColumnDefinition_STRING, 	Key:false},
+			&shim.ColumnDefinition{Name: "Organisation"			, Type:shim.ColumnDefinition_STRING, 	Key:false},
 	})
 	if err != nil{ return nil, errors.New( "Failed creating Brokerage Requests Table")}
 
@@ -233,428 +1191,7867 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 // "create":  true -> create new ID, false -> append the id
 func append_id(stub *shim.ChaincodeStub, indexStr string, id string, create bool) ([]byte, error) {
 
-	indexAsBytes, err := stub.GetState(indexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get " + indexStr)
-	}
-
-	// Unmarshal the index
-	var tmpIndex []string
-	json.Unmarshal(indexAsBytes, &tmpIndex)
-
-	// Create new id
+	// Create new id from the transaction ID, which is unique, and not from a count of the entries
 	var newId = id
 	if create {
-		newId += strconv.Itoa(len(tmpIndex) + 1)
-	}
+		newId += stub.GetTxID()
 
-	// append the new id to the index
-	tmpIndex = append(tmpIndex, newId)
+		// A transaction creating more than one id under the same prefix gets a suffix
+		base := newId
+		for n := 2; ; n++ {
+			exists, err := index_contains(stub, indexStr, newId)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				break
+			}
+			newId = base + "_" + strconv.Itoa(n)
+		}
+	}
 
-	jsonAsBytes, _ := json.Marshal(tmpIndex)
-	err = stub.PutState(indexStr, jsonAsBytes)
+	// add the new id to the index, adding an id twice leaves a single entry
+	err := stub.PutState(index_key(indexStr, newId), []byte(newId))
 	if err != nil {
-		return nil, errors.New("Error storing new " + indexStr + " into ledger")
+		return nil, errors.New("Error storing new " + indexStr + " entry into ledger")
 	}
 
 	return []byte(newId), nil
 
 }
 
-//==============================================================================================================================
-//  Invoke Functions
-//==============================================================================================================================
-func (t *SimpleChaincode) reset_indexes(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	for _, i := range indexes {
-		// Marshal the index
-		var emptyIndex []string
+func index_key(indexStr string, id string) string {
+	return indexStr + "_" + id
+}
 
-		empty, err := json.Marshal(emptyIndex)
-		if err != nil {
-			return nil, errors.New("Error marshalling")
-		}
-		err = stub.PutState(i, empty);
+func index_contains(stub *shim.ChaincodeStub, indexStr string, id string) (bool, error) {
 
-		if err != nil {
-			return nil, errors.New("Error deleting index")
-		}
-		logger.Infof("Delete with success from ledger: " + i)
+	bytes, err := stub.GetState(index_key(indexStr, id))
+	if err != nil {
+		return false, errors.New("Failed to get " + indexStr + " entry " + id)
 	}
-	return nil, nil
+	return bytes != nil, nil
 }
 
-func (t *SimpleChaincode) add_user(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-
-	//Args
-	//			0				1
-	//		  index		user JSON object (as string)
+func remove_id(stub *shim.ChaincodeStub, indexStr string, id string) error {
 
-	id, err := append_id(stub, usersIndexStr, args[0], false)
+	err := stub.DelState(index_key(indexStr, id))
 	if err != nil {
-		return nil, errors.New("Error creating new id for user " + args[0])
+		return errors.New("Error removing " + id + " from " + indexStr)
 	}
+	return nil
+}
+
+// All ids in the index, in key order
+func get_index_ids(stub *shim.ChaincodeStub, indexStr string) ([]string, error) {
 
-	err = stub.PutState(string(id), []byte(args[1]))
+	_, values, err := range_by_prefix(stub, index_key(indexStr, ""))
 	if err != nil {
-		return nil, errors.New("Error putting user data on ledger")
+		return nil, err
 	}
 
-	return nil, nil
+	ids := make([]string, len(values))
+	for i, v := range values {
+		ids[i] = string(v)
+	}
+	return ids, nil
 }
 
-func (t *SimpleChaincode) add_thing(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+// Moves an index stored as a single JSON array under indexStr to one key per entry
+func migrate_legacy_index(stub *shim.ChaincodeStub, indexStr string) (int, error) {
 
-	// args
-	// 		0			1
-	//	   index	   thing JSON object (as string)
-
-	id, err := append_id(stub, thingsIndexStr, args[0], false)
+	indexAsBytes, err := stub.GetState(indexStr)
 	if err != nil {
-		return nil, errors.New("Error creating new id for thing " + args[0])
+		return 0, errors.New("Failed to get " + indexStr)
+	}
+	if indexAsBytes == nil {
+		return 0, nil
 	}
 
-	err = stub.PutState(string(id), []byte(args[1]))
+	var legacyIndex []string
+	err = json.Unmarshal(indexAsBytes, &legacyIndex)
 	if err != nil {
-		return nil, errors.New("Error putting thing data on ledger")
+		return 0, errors.New("Legacy " + indexStr + " is not a JSON array")
 	}
 
-	return nil, nil
+	for _, id := range legacyIndex {
+		_, err = append_id(stub, indexStr, id, false)
+		if err != nil {
+			return 0, err
+		}
+
+		// Brokerage requests live in their table, users and things need their "_version_" key to be found again
+		if indexStr == applicationIndexStr {
+			continue
+		}
+		_, found, err := get_record_meta(stub, id)
+		if err != nil {
+			return 0, err
+		}
+		record, err := stub.GetState(id)
+		if err != nil {
+			return 0, errors.New("Failed to get " + id)
+		}
+		if !found && record != nil {
+			err = put_record_meta(stub, id, RecordMeta{Index: indexStr, Version: 1})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
 
+	err = stub.DelState(indexStr)
+	if err != nil {
+		return 0, errors.New("Error deleting legacy " + indexStr)
+	}
+	return len(legacyIndex), nil
 }
 
-func (t *SimpleChaincode) create_brokerage_request(stub *shim.ChaincodeStub, jsonData string) ([]byte, error) {
+//==============================================================================================================================
+//	 Record versions - Users and things are stored under their id, their index and version under "_version_<id>".
+//	 A create fails on an existing id, an update has to name the version it was based on (optimistic concurrency).
+//	 The "_version_" keys are also what rebuild_index scans to find the actual records of an index.
+//==============================================================================================================================
+var versionPrefix = "_version_"
 
-	fmt.Println("Input request object :: " + jsonData)
+type RecordMeta struct {
+	Index           string   `json:"Index"`
+	Version         int      `json:"Version"`
+}
 
-	/**** Convert the incoming arguments from json to bytearray ****/
-	var bytesArray = []byte(jsonData)
+func get_record_meta(stub *shim.ChaincodeStub, id string) (RecordMeta, bool, error) {
 
-	/**** Copy the incoming json data to a struct b ****/
-	var b BrokerageRequest;
-	json.Unmarshal(bytesArray, &b)
-	fmt.Println("B value :: " + b.RequestID)
+	var meta RecordMeta
 
-	/**** Create an object for inserting TimeStamps ****/
-	timeStampJson := []byte(t.get_current_time())
+	bytes, err := stub.GetState(versionPrefix + id)
+	if err != nil {
+		return meta, false, errors.New("Failed to get version of " + id)
+	}
+	if bytes == nil {
+		return meta, false, nil
+	}
 
-	/****  Insert the details of the Brokerage application into a new row in the Table structure ****/
-	fmt.Println("Inserting row now")
-	tx, err := stub.InsertRow( "BrokerageRequests" , shim.Row{
-				Columns: []*shim.Column{
-					&shim.Column{Value: &shim.Column_String_{String_: b.RequestID}},
-					&shim.Column{Value: &shim.Column_String_{String_: b.Submitter}},
-					&shim.Column{Value: &shim.Column_String_{String_: b.Approver}},
-					&shim.Column{Value: &shim.Column_Bytes	{Bytes: []byte(b.Documents)}},
-					&shim.Column{Value: &shim.Column_Bytes	{Bytes: []byte(b.PersonalDetails)}},
-					&shim.Column{Value: &shim.Column_Bytes	{Bytes: []byte(b.KYCDetails)}},
-					&shim.Column{Value: &shim.Column_String_{String_: b.Status}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.DocValidationReport)}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.FacialValidation)}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.Video)}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(timeStampJson)}},
-					&shim.Column{Value: &shim.Column_String_{String_: b.Meeting}},
-				},
-			})
-	fmt.Println("ERROR :: " , err)
-
-	if err != nil {
-		fmt.Println("Error while updating record :: ", b.RequestID)
-	}else{
-		fmt.Println("Response from Insert Row ::", tx)
+	err = json.Unmarshal(bytes, &meta)
+	if err != nil {
+		return meta, false, errors.New("Corrupt version of " + id)
 	}
-	
-	/*Returning nil response for now*/
-	return nil, nil
+	return meta, true, nil
 }
 
-func (t *SimpleChaincode) update_brokerage_application(stub *shim.ChaincodeStub, jsonData string) ([]byte, error) {
-
-	fmt.Println("Input request object :: " + jsonData)
+func put_record_meta(stub *shim.ChaincodeStub, id string, meta RecordMeta) error {
 
-	/****New Data to be written****/
-	var bytesArray = []byte(jsonData)
+	bytes, _ := json.Marshal(meta)
+	err := stub.PutState(versionPrefix + id, bytes)
+	if err != nil {
+		return errors.New("Error putting version of " + id + " on ledger")
+	}
+	return nil
+}
 
-	var inputBrokerageRequest BrokerageRequest
+func get_version(stub *shim.ChaincodeStub, id string) (int, error) {
 
-	fmt.Println("Unmarshalling data to BrokerageRequest")
-	json.Unmarshal(bytesArray, &inputBrokerageRequest)
+	meta, found, err := get_record_meta(stub, id)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 1, nil	// Stored before versions were kept
+	}
+	return meta.Version, nil
+}
 
-	/****First get the data stored****/
-	fmt.Println("Fetching data from table BrokerageRequests")
-	brokerageRequestRow := t.fetch_from_table(stub, inputBrokerageRequest.RequestID)
+func create_record(stub *shim.ChaincodeStub, indexStr string, id string, value []byte) error {
 
-	/****Convert to local Struct here****/
-	fmt.Println("Converting Row to Struct data from table BrokerageRequests")
-	brokerageRequest := t.getStructFromRow(brokerageRequestRow)	
-	
-	var timeStampJson []byte
+	existing, err := stub.GetState(id)
+	if err != nil {
+		return errors.New("Failed to get " + id)
+	}
+	if existing != nil {
+		return errors.New(id + " already exists")
+	}
 
-	updateType := inputBrokerageRequest.UpdateType
+	value, err = seal_user_value(stub, indexStr, id, value)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(id, value)
+	if err != nil {
+		return errors.New("Error putting " + id + " on ledger")
+	}
 
-	fmt.Println("UpdateType is :: " + updateType)
+	err = append_history(stub, indexStr, id, value)
+	if err != nil {
+		return err
+	}
 
-	if updateType == "MEETING" {
-		brokerageRequest.Meeting = jsonData
-		timeStampJson = []byte(t.get_current_time())
-	}else if updateType == "VIDEO" {
-		brokerageRequest.Video = jsonData
-	}else if updateType == "STATUS"{
-		brokerageRequest.Status = jsonData
+	err = put_record_meta(stub, id, RecordMeta{Index: indexStr, Version: 1})
+	if err != nil {
+		return err
 	}
-	
-	fmt.Println("Replacing row now...")
-	/**** Store the data ****/
-		tx,err := stub.ReplaceRow( "BrokerageRequests" , shim.Row{
-				Columns: []*shim.Column{
-					&shim.Column{Value: &shim.Column_String_{String_: brokerageRequest.RequestID}},
-					&shim.Column{Value: &shim.Column_String_{String_: brokerageRequest.Submitter}},
-					&shim.Column{Value: &shim.Column_String_{String_: brokerageRequest.Approver}},
-					&shim.Column{Value: &shim.Column_Bytes	{Bytes: []byte(brokerageRequest.Documents)}},
-					&shim.Column{Value: &shim.Column_Bytes	{Bytes: []byte(brokerageRequest.PersonalDetails)}},
-					&shim.Column{Value: &shim.Column_Bytes	{Bytes: []byte(brokerageRequest.KYCDetails)}},
-					&shim.Column{Value: &shim.Column_String_{String_: brokerageRequest.Status}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(brokerageRequest.DocValidationReport)}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(brokerageRequest.FacialValidation)}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(brokerageRequest.Video)}},
-					&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(timeStampJson)}},
-					&shim.Column{Value: &shim.Column_String_{String_: brokerageRequest.Meeting}},
-				},
-		})
-		fmt.Println("Error ::: ",err)
 
-		if err != nil {
-			fmt.Println("Error while updating record :: ", brokerageRequest.RequestID)
-		}else{
-			fmt.Println("Response from Insert Row ::", tx)
-		}
-	
-	return timeStampJson, nil
+	// Indexed last, a failed write (kept going in partial batches) leaves no entry pointing at nothing
+	_, err = append_id(stub, indexStr, id, false)
+	return err
 }
 
-func (t *SimpleChaincode) toJson()(string){
-	var returnValue string;
-	
-	return returnValue
-}
+func update_record(stub *shim.ChaincodeStub, indexStr string, id string, value []byte, expectedVersion string) (int, error) {
 
-func (t *SimpleChaincode) get_current_time() ([]byte) {
-	var timeStampObject BrokerageRequestTimeStamp
-	timenow := time.Now()
-	fmt.Printf("Time.now %s", timenow)
-	timeStampObject.Submit = timenow.UTC().Format(time.UnixDate);
-    timeStampJson, _ := json.Marshal(timeStampObject)
+	exists, err := index_contains(stub, indexStr, id)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, errors.New(id + " does not exist")
+	}
 
-	return timeStampJson
-}
+	current, err := get_version(stub, id)
+	if err != nil {
+		return 0, err
+	}
+	if strconv.Itoa(current) != expectedVersion {
+		return 0, errors.New("Version conflict on " + id + ", expected " + expectedVersion + " but it is " + strconv.Itoa(current))
+	}
+
+	value, err = seal_user_value(stub, indexStr, id, value)
+	if err != nil {
+		return 0, err
+	}
+	err = stub.PutState(id, value)
+	if err != nil {
+		return 0, errors.New("Error putting " + id + " on ledger")
+	}
 
+	err = append_history(stub, indexStr, id, value)
+	if err != nil {
+		return 0, err
+	}
 
+	err = put_record_meta(stub, id, RecordMeta{Index: indexStr, Version: current + 1})
+	if err != nil {
+		return 0, err
+	}
+	return current + 1, nil
+}
 
 /*
-	Return the struct from the table.
+	The personal details of a user go to "privateuser_<userId>", the user record and its history keep their hash.
+	Other records are written as they are.
 */
-func(t *SimpleChaincode) getStructFromRow(row shim.Row)(BrokerageRequest){
-	
-	var brokerageRequest BrokerageRequest
-	for index := range row.Columns {
-		column := row.Columns[index]
-		if index == 0 {
-			brokerageRequest.RequestID = column.GetString_()
-		}else if index == 1 {
-			brokerageRequest.Submitter = column.GetString_()
-		}else if index == 2 {
-			brokerageRequest.Approver = column.GetString_()
-		}else if index == 3 {
-			brokerageRequest.Documents = column.GetString_()
-		}else if index == 4 {
-			brokerageRequest.PersonalDetails = column.GetString_()
-		}else if index == 5 {
-			brokerageRequest.KYCDetails = column.GetString_()
-		}else if index == 6 {
-			brokerageRequest.Status = column.GetString_()
-		}else if index == 7 {
-			brokerageRequest.DocValidationReport = column.GetString_()
-		}else if index == 8 {
-			brokerageRequest.FacialValidation = column.GetString_()
-		}else if index == 9 {
-			brokerageRequest.Video = column.GetString_()
-		}else if index == 10 {
-			brokerageRequest.TimeStamps = column.GetString_()
-		}else if index == 11 {
-			brokerageRequest.Meeting = column.GetString_()
+func seal_user_value(stub *shim.ChaincodeStub, indexStr string, id string, value []byte) ([]byte, error) {
+
+	var fields map[string]json.RawMessage
+	if indexStr != usersIndexStr || json.Unmarshal(value, &fields) != nil {
+		return value, nil
+	}
+
+	delete(fields, "personalDetailsHash")
+	details := fields["personalDetails"]
+	delete(fields, "personalDetails")
+	if details == nil || string(details) == "null" {
+		err := stub.DelState(privateUserPrefix + id)
+		if err != nil {
+			return nil, errors.New("Error removing personal details of " + id)
 		}
-		index ++
+		return json.Marshal(fields)
 	}
-	return brokerageRequest
-}
 
-/*This function helps in getting the data stored from local database*/
-func (t *SimpleChaincode) fetch_from_table(stub *shim.ChaincodeStub, requestId string)(shim.Row){
-	fmt.Println("Fetching Row for RequestID :: " + requestId)
-	var columns []shim.Column
-	row := shim.Row{
-		Columns: []*shim.Column{
-					&shim.Column{Value: &shim.Column_String_{String_: requestId}},
-					//&shim.Column{Value: &shim.Column_String_{String_: submitter}},
-		},
+	hashes, _, err := seal_fields(stub, privateUserPrefix + id, map[string]string{"personalDetails": string(details)})
+	if err != nil {
+		return nil, err
 	}
-	queryCol := shim.Column{Value: &shim.Column_String_{String_: requestId}}
-	columns = append(columns, queryCol)
-	row.Columns[0].GetString_()
-	//row.Columns[1].GetString_()
-	row, _ = stub.GetRow("BrokerageRequests", columns)
-	fmt.Println("ROW :: ", row)
-	uid:= row.Columns[0].GetString_()
-	submitter := row.Columns[1].GetString_()
-	fmt.Println("UID is " + uid)
-	fmt.Println("Submitter is" + submitter)
-	return row
+	fields["personalDetailsHash"], _ = json.Marshal(hashes["personalDetails"])
+	return json.Marshal(fields)
 }
 
-
-
 //==============================================================================================================================
-//		Query Functions
+//	 Record history - Every write of a user, thing or brokerage request also stores a snapshot under
+//	 "history<index>_<id>|<time>|<txid>", the shim keeps no history of its own.
 //==============================================================================================================================
+var historyPrefix = "history"
 
-func (t *SimpleChaincode) get_user(stub *shim.ChaincodeStub, userID string) ([]byte, error) {
+type HistoryEntry struct {
+	TxID            string          `json:"TxID"`
+	Timestamp       string          `json:"Timestamp"`
+	Writer          string          `json:"Writer"`
+	Value           json.RawMessage `json:"Value"`
+}
 
-	bytes, err := stub.GetState(userID)
+func history_key_prefix(indexStr string, id string) string {
+	return historyPrefix + indexStr + "_" + id + "|"
+}
+
+func append_history(stub *shim.ChaincodeStub, indexStr string, id string, value []byte) error {
 
+	ts, err := stub.GetTxTimestamp()
 	if err != nil {
-		return nil, errors.New("Could not retrieve information for this user")
+		return errors.New("Could not get transaction timestamp")
 	}
+	now := time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
 
-	return bytes, nil
+	// A missing attribute is recorded as an empty writer rather than failing the write
+	writer, _ := stub.ReadCertAttribute("username")
 
-}
+	entry := HistoryEntry{
+		TxID:      stub.GetTxID(),
+		Timestamp: now.Format(time.RFC3339Nano),
+		Writer:    string(writer),
+		Value:     json.RawMessage(value),
+	}
+	var probe interface{}
+	if json.Unmarshal(value, &probe) != nil {
+		entry.Value, _ = json.Marshal(string(value))	// Not JSON, keep it as a string
+	}
+	entryAsBytes, _ := json.Marshal(entry)
 
-func (t *SimpleChaincode) get_thing(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	// The same record written twice in one transaction gets a suffix
+	key := history_key_prefix(indexStr, id) + now.Format("20060102T150405.000000000") + "|" + entry.TxID
+	base := key
+	for n := 2; ; n++ {
+		existing, err := stub.GetState(key)
+		if err != nil {
+			return errors.New("Failed to get history of " + id)
+		}
+		if existing == nil {
+			break
+		}
+		key = base + "|" + strconv.Itoa(n)
+	}
 
-	//Args
-	//			0
-	//		thingID
+	err = stub.PutState(key, entryAsBytes)
+	if err != nil {
+		return errors.New("Error putting history of " + id + " on ledger")
+	}
+	return nil
+}
 
-	bytes, err := stub.GetState(args[0])
+//==============================================================================================================================
+//	 write_audit - Appends an entry to the audit log. Details are stored as JSON.
+//==============================================================================================================================
+func (t *SimpleChaincode) write_audit(stub *shim.ChaincodeStub, action string, subject string, details interface{}) error {
 
+	now, err := t.get_tx_time(stub)
 	if err != nil {
-		return nil, errors.New("Error getting from ledger")
+		return err
 	}
 
-	return bytes, nil
+	// Missing attributes are recorded as empty, they don't stop the operation being audited
+	actor, _ := t.get_username(stub)
+	role, _ := t.get_role(stub)
 
-}
+	detailsAsBytes, _ := json.Marshal(details)
 
-func (t *SimpleChaincode) get_all_things(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	entry := AuditEntry{
+		TxID:      stub.GetTxID(),
+		Timestamp: now.Format(time.RFC3339Nano),
+		Actor:     actor,
+		Role:      role,
+		Action:    action,
+		Subject:   subject,
+		Details:   string(detailsAsBytes),
+	}
+	entryAsBytes, _ := json.Marshal(entry)
 
-	indexAsBytes, err := stub.GetState(thingsIndexStr)
+	key := auditPrefix + subject + "|" + now.Format("20060102T150405.000000000") + "|" + entry.TxID
+	err = stub.PutState(key, entryAsBytes)
 	if err != nil {
-		return nil, errors.New("Failed to get " + thingsIndexStr)
+		return errors.New("Error putting audit entry on ledger")
 	}
+	return nil
+}
 
-	// Unmarshal the index
-	var thingsIndex []string
-	json.Unmarshal(indexAsBytes, &thingsIndex)
+/*
+	All audit entries of a subject, oldest first.
+*/
+func (t *SimpleChaincode) get_audit_entries(stub *shim.ChaincodeStub, subject string) ([]AuditEntry, error) {
 
-	var things []Thing
-	for _, thing := range thingsIndex {
+	keys, values, err := range_by_prefix(stub, auditPrefix + subject + "|")
+	if err != nil {
+		return nil, err
+	}
 
-		bytes, err := stub.GetState(thing)
+	entries := []AuditEntry{}
+	for i := range keys {
+		var e AuditEntry
+		err = json.Unmarshal(values[i], &e)
 		if err != nil {
-			return nil, errors.New("Unable to get thing with ID: " + thing)
+			return nil, errors.New("Corrupt audit entry " + keys[i])
 		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
 
-		var t Thing
-		json.Unmarshal(bytes, &t)
-		things = append(things, t)
+/*
+	The ids that actually have a record: rows of the BrokerageRequests table for the applications index, for users
+	and things the "_version_" keys whose record still exists.
+*/
+func (t *SimpleChaincode) actual_record_ids(stub *shim.ChaincodeStub, indexStr string) ([]string, error) {
+
+	var ids []string
+
+	if indexStr == applicationIndexStr {
+		rows, err := stub.GetRows("BrokerageRequests", []shim.Column{})
+		if err != nil {
+			return nil, errors.New("Failed to scan BrokerageRequests")
+		}
+		for row := range rows {
+			if len(row.Columns) > 0 {
+				ids = append(ids, row.Columns[0].GetString_())
+			}
+		}
+		return ids, nil
 	}
 
-	thingsAsJsonBytes, _ := json.Marshal(things)
+	keys, values, err := range_by_prefix(stub, versionPrefix)
 	if err != nil {
-		return nil, errors.New("Could not convert things to JSON ")
+		return nil, err
 	}
+	for i := range keys {
+		var meta RecordMeta
+		if json.Unmarshal(values[i], &meta) != nil || meta.Index != indexStr {
+			continue
+		}
 
-	return thingsAsJsonBytes, nil
+		id := keys[i][len(versionPrefix):]
+		record, err := stub.GetState(id)
+		if err != nil {
+			return nil, errors.New("Failed to get " + id)
+		}
+		if record != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
 }
 
-func (t *SimpleChaincode) authenticate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-
-	// Args
-	//	0		1
-	//	userId	password
-
-	var u User
-
-	username := args[0]
+func (t *SimpleChaincode) index_drift(stub *shim.ChaincodeStub, indexStr string) (IndexDriftReport, error) {
 
-	user, err := t.get_user(stub, username)
+	report := IndexDriftReport{Index: indexStr, Missing: []string{}, Orphaned: []string{}}
 
-	// If user can not be found in ledgerstore, return authenticated false
+	entries, err := get_index_ids(stub, indexStr)
 	if err != nil {
-		return []byte(`{ "authenticated": false }`), nil
+		return report, err
 	}
-
-	//Check if the user is an employee, if not return error message
-	err = json.Unmarshal(user, &u)
+	records, err := t.actual_record_ids(stub, indexStr)
 	if err != nil {
-		return []byte(`{ "authenticated": false}`), nil
+		return report, err
 	}
+	report.Entries = len(entries)
+	report.Records = len(records)
 
-	// Marshal the user object
-	userAsBytes, err := json.Marshal(u)
-	if err != nil {
-		return []byte(`{ "authenticated": false}`), nil
+	inIndex := map[string]bool{}
+	for _, id := range entries {
+		inIndex[id] = true
 	}
-
-	// Return authenticated true, and include the user object
-	str := `{ "authenticated": true, "user": ` + string(userAsBytes) + `  }`
-
-	return []byte(str), nil
+	hasRecord := map[string]bool{}
+	for _, id := range records {
+		hasRecord[id] = true
+		if !inIndex[id] {
+			report.Missing = append(report.Missing, id)
+		}
+	}
+	for _, id := range entries {
+		if !hasRecord[id] {
+			report.Orphaned = append(report.Orphaned, id)
+		}
+	}
+	return report, nil
 }
 
+//==============================================================================================================================
+//	 Listings - Walks an index in key order from the bookmark, loads each record, keeps the ones matching the
+//	 selector and stops after a page. Only the records of one page plus the non-matching ones in between are read.
+//==============================================================================================================================
+func parse_listing_query(arg string) (ListingQuery, error) {
 
-func (t *SimpleChaincode) add_resource(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	var q ListingQuery
+	if arg != "" {
+		err := json.Unmarshal([]byte(arg), &q)
+		if err != nil {
+			return q, errors.New("Invalid query JSON")
+		}
+	}
 
-    fmt.Println("Chaincode running AddResource()")
-	fmt.Println(args[0])
-    id := args[0] + args[1]
-	fmt.Println("KEY value for invoke - add resource -->> " +id)
-    err := stub.PutState(string(id), []byte(args[2]))
-	if err != nil {
-		return nil, errors.New("Error putting resource data on ledger")
+	if q.Limit <= 0 {
+		q.Limit = defaultPageSize
+	}
+	if q.Limit > maxPageSize {
+		q.Limit = maxPageSize
 	}
-    return nil, nil   
 
+	for field, condition := range q.Selector {
+		if ops, ok := condition.(map[string]interface{}); ok {
+			for op := range ops {
+				if op != "$eq" && op != "$ne" && op != "$gt" && op != "$gte" && op != "$lt" && op != "$lte" && op != "$prefix" {
+					return q, errors.New("Unknown operator " + op + " on " + field)
+				}
+			}
+		}
+	}
+	return q, nil
 }
 
-func (t *SimpleChaincode) get_resource(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func run_listing(stub *shim.ChaincodeStub, indexStr string, q ListingQuery, load func(id string) (interface{}, error)) (ListingPage, error) {
 
-    fmt.Println("Chaincode running GetResource()")
-	fmt.Println(args[0])
-	fmt.Println(args[1])
-    id := args[0] + args[1]
-	fmt.Println("ID = " + id)
-    path, err := stub.GetState(string(id))
-	fmt.Println("PATH IS  = " + string(path))
+	page := ListingPage{Records: []map[string]interface{}{}}
+	lastId := ""
+
+	prefix := index_key(indexStr, "")
+	startKey := index_key(indexStr, q.Bookmark)
+	iter, err := stub.RangeQueryState(startKey, prefix_end(prefix))
 	if err != nil {
-		return nil, errors.New("Error getting resource data from ledger")
+		return page, errors.New("Failed to query " + indexStr)
 	}
-    return path, nil
+	defer iter.Close()
 
-    
-}
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return page, errors.New("Failed to iterate " + indexStr)
+		}
+		id := string(value)
+		if (q.Bookmark != "" && key == startKey) || !strings.HasPrefix(key, prefix) {
+			continue
+		}
 
-func (t *SimpleChaincode) get_brokerage_request(stub *shim.ChaincodeStub, requestId string) ([]byte, error) {
- 	fmt.Println("Chaincode running get_brokerage_request()")
-	 row := t.fetch_from_table(stub, requestId)
-	 structure := t.getStructFromRow(row)
-	 bytesArray,_ := json.Marshal(structure)
-	 return bytesArray,nil
-}
+		record, err := load(id)
+		if err != nil {
+			return page, err
+		}
+		if record == nil {
+			continue	// Hidden from this listing
+		}
 
-func (t *SimpleChaincode) get_all_brokerage_requests(stub *shim.ChaincodeStub, requestId string) ([]byte, error) {
- 	fmt.Println("Chaincode running get_brokerage_request()")
-	 row := t.fetch_from_table(stub, requestId)
-	 structure := t.getStructFromRow(row)
-	 bytesArray,_ := json.Marshal(structure)
-	 return bytesArray,nil
+		// Match on the JSON form, so the field names are the ones the client sees
+		recordAsBytes, _ := json.Marshal(record)
+		var fields map[string]interface{}
+		err = json.Unmarshal(recordAsBytes, &fields)
+		if err != nil {
+			return page, errors.New("Record " + id + " is not a JSON object")
+		}
+
+		if !selector_matches(q.Selector, fields) {
+			continue
+		}
+
+		if page.Count == q.Limit {
+			// There is at least one more match, so hand out a bookmark
+			page.Bookmark = lastId
+			return page, nil
+		}
+
+		page.Records = append(page.Records, project_fields(fields, q.Fields))
+		page.Count++
+		lastId = id
+	}
+	return page, nil
+}
+
+func selector_matches(selector map[string]interface{}, record map[string]interface{}) bool {
+
+	for field, condition := range selector {
+		value, found := field_value(record, field)
+
+		ops, isOps := condition.(map[string]interface{})
+		if !isOps {
+			ops = map[string]interface{}{"$eq": condition}
+		}
+
+		for op, operand := range ops {
+			if !found {
+				// A missing field only satisfies $ne, the other operators of the selector are still checked
+				if op != "$ne" {
+					return false
+				}
+				continue
+			}
+			if !compare_values(op, value, operand) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Dotted paths reach into nested objects, e.g. "Inputs.Country"
+func field_value(record map[string]interface{}, path string) (interface{}, bool) {
+
+	var current interface{} = record
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// Strings compare with strings and numbers with numbers, anything else only matches $ne
+func compare_values(op string, value interface{}, operand interface{}) bool {
+
+	if op == "$prefix" {
+		s, ok1 := value.(string)
+		p, ok2 := operand.(string)
+		return ok1 && ok2 && strings.HasPrefix(s, p)
+	}
+
+	cmp := 0
+	switch v := value.(type) {
+	case string:
+		o, ok := operand.(string)
+		if !ok {
+			return op == "$ne"
+		}
+		cmp = strings.Compare(v, o)
+	case float64:
+		o, ok := operand.(float64)
+		if !ok {
+			return op == "$ne"
+		}
+		if v < o {
+			cmp = -1
+		} else if v > o {
+			cmp = 1
+		}
+	case bool:
+		o, ok := operand.(bool)
+		if !ok || (op != "$eq" && op != "$ne") {
+			return op == "$ne"
+		}
+		if v != o {
+			cmp = 1
+		}
+	default:
+		return op == "$ne"
+	}
+
+	switch op {
+	case "$eq":
+		return cmp == 0
+	case "$ne":
+		return cmp != 0
+	case "$gt":
+		return cmp > 0
+	case "$gte":
+		return cmp >= 0
+	case "$lt":
+		return cmp < 0
+	case "$lte":
+		return cmp <= 0
+	}
+	return false
+}
+
+func project_fields(record map[string]interface{}, fields []string) map[string]interface{} {
+
+	if len(fields) == 0 {
+		return record
+	}
+
+	projected := map[string]interface{}{}
+	for _, f := range fields {
+		if v, ok := record[f]; ok {
+			projected[f] = v
+		}
+	}
+	return projected
+}
+
+//==============================================================================================================================
+//	 range_by_prefix - Returns all keys starting with the prefix and their values, in key order
+//==============================================================================================================================
+func range_by_prefix(stub *shim.ChaincodeStub, prefix string) ([]string, [][]byte, error) {
+
+	iter, err := stub.RangeQueryState(prefix, prefix_end(prefix))
+	if err != nil {
+		return nil, nil, errors.New("Failed to query keys with prefix " + prefix)
+	}
+	defer iter.Close()
+
+	var keys []string
+	var values [][]byte
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, nil, errors.New("Failed to iterate keys with prefix " + prefix)
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue	// The end key itself, the range is inclusive
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
+
+// Smallest key above every key starting with the prefix: the prefix with its last byte incremented. Appending "~"
+// instead would miss keys continuing with a byte above it, e.g. non-ASCII ids.
+func prefix_end(prefix string) string {
+
+	end := []byte(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) == 0 {
+		return ""
+	}
+	end[len(end)-1]++
+	return string(end)
+}
+
+//==============================================================================================================================
+//	 get_username - Retrieves the username of the caller from the attributes of the ECert
+//==============================================================================================================================
+func (t *SimpleChaincode) get_username(stub *shim.ChaincodeStub) (string, error) {
+
+	username, err := stub.ReadCertAttribute("username")
+	if err != nil {
+		return "", errors.New("Couldn't get attribute 'username'. Error: " + err.Error())
+	}
+	return string(username), nil
+}
+
+//==============================================================================================================================
+//	 get_role - Retrieves the role of the caller from the attributes of the ECert
+//==============================================================================================================================
+func (t *SimpleChaincode) get_role(stub *shim.ChaincodeStub) (string, error) {
+
+	role, err := stub.ReadCertAttribute("role")
+	if err != nil {
+		return "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error())
+	}
+	return string(role), nil
+}
+
+//==============================================================================================================================
+//	 get_tx_time - The transaction timestamp. Every peer sees the same value, unlike time.Now()
+//==============================================================================================================================
+func (t *SimpleChaincode) get_tx_time(stub *shim.ChaincodeStub) (time.Time, error) {
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("Could not get transaction timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+//==============================================================================================================================
+//	 get_accessor_struct - Loads a registered KyckAccessor from the ledger
+//==============================================================================================================================
+func (t *SimpleChaincode) get_accessor_struct(stub *shim.ChaincodeStub, accessorId string) (KyckAccessor, error) {
+
+	var a KyckAccessor
+
+	bytes, err := stub.GetState(accessorPrefix + accessorId)
+	if err != nil {
+		return a, errors.New("Error getting accessor " + accessorId + " from ledger")
+	}
+	if bytes == nil {
+		return a, errors.New("Accessor " + accessorId + " is not registered")
+	}
+
+	err = json.Unmarshal(bytes, &a)
+	if err != nil {
+		return a, errors.New("Corrupt accessor record " + accessorId)
+	}
+	return a, nil
+}
+
+//==============================================================================================================================
+//	 caller_accessor_with_role - Returns the caller's accessor record if the caller is registered with the given role
+//==============================================================================================================================
+func (t *SimpleChaincode) caller_accessor_with_role(stub *shim.ChaincodeStub, role string) (KyckAccessor, error) {
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return KyckAccessor{}, err
+	}
+
+	a, err := t.get_accessor_struct(stub, username)
+	if err != nil {
+		return a, err
+	}
+
+	if !has_role(a, role) {
+		return a, errors.New("Accessor " + username + " does not have the role " + role)
+	}
+	return a, nil
+}
+
+func (t *SimpleChaincode) caller_is_regulator(stub *shim.ChaincodeStub) (bool, error) {
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return false, err
+	}
+
+	a, err := t.get_accessor_struct(stub, username)
+	if err != nil {
+		return false, nil	// Not an accessor at all
+	}
+	return has_role(a, roleRegulator), nil
+}
+
+/**** The approver named on a request is a registered broker and not the customer, empty leaves it unassigned ****/
+func (t *SimpleChaincode) check_request_approver(stub *shim.ChaincodeStub, approver string, submitter string) error {
+
+	if approver == "" {
+		return nil
+	}
+	if approver == submitter {
+		return errors.New("The submitter of a brokerage request cannot be its approver")
+	}
+	a, err := t.get_accessor_struct(stub, approver)
+	if err != nil {
+		return err
+	}
+	if !has_role(a, roleBroker) {
+		return errors.New("Accessor " + approver + " is not a broker")
+	}
+	return nil
+}
+
+func has_role(a KyckAccessor, role string) bool {
+	for _, r := range a.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//==============================================================================================================================
+//	 get_brokerage_request_struct - Loads a brokerage request, fails when it does not exist
+//==============================================================================================================================
+func (t *SimpleChaincode) get_brokerage_request_struct(stub *shim.ChaincodeStub, requestId string) (BrokerageRequest, error) {
+
+	row, err := t.fetch_from_table(stub, requestId)
+	if err != nil {
+		return BrokerageRequest{}, err
+	}
+	return t.getStructFromRow(row), nil
+}
+
+//==============================================================================================================================
+//	 get_kyck_user_struct - Loads the stored KYC package of a customer
+//==============================================================================================================================
+func (t *SimpleChaincode) get_kyck_user_struct(stub *shim.ChaincodeStub, userId string) (KyckUser, error) {
+
+	k, err := t.get_stored_kyck_user(stub, userId)
+	if err != nil {
+		return k, err
+	}
+
+	values, found, err := open_fields(stub, privateKYCPrefix + userId, kyc_fields(k))
+	if err != nil {
+		return k, err
+	}
+	if found {
+		set_kyc_fields(&k, values)
+	}
+	return k, nil
+}
+
+/**** The KYC package as the ledger holds it, with the hashes of its PII ****/
+func (t *SimpleChaincode) get_stored_kyck_user(stub *shim.ChaincodeStub, userId string) (KyckUser, error) {
+
+	var k KyckUser
+
+	bytes, err := stub.GetState(kyckUserPrefix + userId)
+	if err != nil {
+		return k, errors.New("Error getting KYC package of " + userId + " from ledger")
+	}
+	if bytes == nil {
+		return k, errors.New("No KYC package stored for " + userId)
+	}
+
+	err = json.Unmarshal(bytes, &k)
+	if err != nil {
+		return k, errors.New("Corrupt KYC package of " + userId)
+	}
+	return k, nil
+}
+
+//==============================================================================================================================
+//	 put_kyck_user - Stores the KYC package of a customer, its PII moved to the restricted "privatekyc_<userId>"
+//==============================================================================================================================
+func (t *SimpleChaincode) put_kyck_user(stub *shim.ChaincodeStub, k KyckUser) error {
+
+	hashes, _, err := seal_fields(stub, privateKYCPrefix + k.UserId, kyc_fields(k))
+	if err != nil {
+		return err
+	}
+	set_kyc_fields(&k, hashes)
+
+	bytes, _ := json.Marshal(k)
+	err = stub.PutState(kyckUserPrefix + k.UserId, bytes)
+	if err != nil {
+		return errors.New("Error putting KYC package of " + k.UserId + " on ledger")
+	}
+	return nil
+}
+
+/**** The PII of a KYC package, by field ****/
+func kyc_fields(k KyckUser) map[string]string {
+	return map[string]string{
+		"firstName":           k.FirstName,
+		"lastName":            k.LastName,
+		"address":             k.Address,
+		"phoneNumber":         k.PhoneNumber,
+		"Documents":           string(k.Documents),
+		"PersonalDetails":     string(k.PersonalDetails),
+		"KYCDetails":          string(k.KYCDetails),
+		"DocValidationReport": string(k.DocValidationReport),
+	}
+}
+
+func set_kyc_fields(k *KyckUser, values map[string]string) {
+	k.FirstName = values["firstName"]
+	k.LastName = values["lastName"]
+	k.Address = values["address"]
+	k.PhoneNumber = values["phoneNumber"]
+	k.Documents = []byte(values["Documents"])
+	k.PersonalDetails = []byte(values["PersonalDetails"])
+	k.KYCDetails = []byte(values["KYCDetails"])
+	k.DocValidationReport = []byte(values["DocValidationReport"])
+}
+
+//==============================================================================================================================
+//	 brokerage_row - Builds the BrokerageRequests table row for a brokerage request, columns in table order
+//==============================================================================================================================
+func brokerage_row(b BrokerageRequest) shim.Row {
+
+	var closure []byte
+	if b.Closure != nil {
+		closure, _ = json.Marshal(b.Closure)
+	}
+
+	return shim.Row{
+		Columns: []*shim.Column{
+			&shim.Column{Value: &shim.Column_String_{String_: b.RequestID}},
+			&shim.Column{Value: &shim.Column_String_{String_: b.Submitter}},
+			&shim.Column{Value: &shim.Column_String_{String_: b.Approver}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.Documents)}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.PersonalDetails)}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.KYCDetails)}},
+			&shim.Column{Value: &shim.Column_String_{String_: b.Status}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.DocValidationReport)}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.FacialValidation)}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.Video)}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: []byte(b.TimeStamps)}},
+			&shim.Column{Value: &shim.Column_String_{String_: b.Meeting}},
+			&shim.Column{Value: &shim.Column_String_{String_: b.KYCPackageRef}},
+			&shim.Column{Value: &shim.Column_Bytes{Bytes: closure}},
+			&shim.Column{Value: &shim.Column_String_{String_: b.EntityId}},
+			&shim.Column{Value: &shim.Column_String_{String_: b.Organisation}},
+		},
+	}
+}
+
+//==============================================================================================================================
+//	 insert_brokerage_request - Inserts a new row, adds it to the applications index and starts its history
+//==============================================================================================================================
+func (t *SimpleChaincode) insert_brokerage_request(stub *shim.ChaincodeStub, b BrokerageRequest) error {
+
+	/**** Scoped to the approver's organisation, unassigned requests get theirs on assignment ****/
+	b.Organisation = ""
+	if a, err := t.get_accessor_struct(stub, b.Approver); err == nil {
+		b.Organisation = a.Organisation
+	}
+
+	_, err := t.seal_payload(stub, &b)
+	if err != nil {
+		return err
+	}
+
+	ok, err := stub.InsertRow("BrokerageRequests", brokerage_row(b))
+	if err != nil {
+		return errors.New("Error inserting brokerage request " + b.RequestID)
+	}
+	if !ok {
+		return errors.New("Brokerage request " + b.RequestID + " already exists")
+	}
+
+	_, err = append_id(stub, applicationIndexStr, b.RequestID, false)
+	if err != nil {
+		return err
+	}
+	err = t.index_customer(stub, b)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(userRequestPrefix + b.Submitter + "|" + b.RequestID, []byte(b.RequestID))
+	if err != nil {
+		return errors.New("Error adding " + b.RequestID + " to the requests of " + b.Submitter)
+	}
+
+	requestAsBytes, _ := json.Marshal(b)
+	return append_history(stub, applicationIndexStr, b.RequestID, requestAsBytes)
+}
+
+func submit_time_stamps(submitted time.Time) string {
+
+	var timeStampObject BrokerageRequestTimeStamp
+	timeStampObject.Submit = submitted.Format(time.UnixDate)
+	timeStampJson, _ := json.Marshal(timeStampObject)
+	return string(timeStampJson)
+}
+
+//==============================================================================================================================
+//	 replace_brokerage_request - Writes every column of the brokerage request back to the table
+//==============================================================================================================================
+func (t *SimpleChaincode) replace_brokerage_request(stub *shim.ChaincodeStub, b BrokerageRequest) error {
+
+	ok, err := stub.ReplaceRow("BrokerageRequests", brokerage_row(b))
+	if err != nil {
+		return errors.New("Error while updating brokerage request " + b.RequestID)
+	}
+	if !ok {
+		return errors.New("Brokerage request " + b.RequestID + " does not exist")
+	}
+
+	requestAsBytes, _ := json.Marshal(b)
+	return append_history(stub, applicationIndexStr, b.RequestID, requestAsBytes)
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+//==============================================================================================================================
+//	 rebuild_index - Compares an index with the records that actually exist and, unless it is a dry run, adds the
+//					 missing entries and removes the orphaned ones. Admin only. The drift report is returned and
+//					 kept in the audit log either way.
+//==============================================================================================================================
+func (t *SimpleChaincode) rebuild_index(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		indexStr		dry run ("true" or "false")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can rebuild indexes")
+	}
+
+	if !is_index(args[0]) {
+		return nil, errors.New("Unknown index " + args[0])
+	}
+	dryRun, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return nil, errors.New("Dry run must be true or false")
+	}
+
+	report, err := t.index_drift(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun
+
+	if !dryRun {
+		for _, id := range report.Missing {
+			_, err = append_id(stub, args[0], id, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, id := range report.Orphaned {
+			err = remove_id(stub, args[0], id)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = t.write_audit(stub, "rebuild_index", args[0], report)
+	if err != nil {
+		return nil, err
+	}
+
+	reportAsBytes, _ := json.Marshal(report)
+	return reportAsBytes, nil
+}
+
+func (t *SimpleChaincode) migrate_indexes(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can migrate indexes")
+	}
+
+	migrated := map[string]int{}
+	for _, i := range indexes {
+		n, err := migrate_legacy_index(stub, i)
+		if err != nil {
+			return nil, err
+		}
+		migrated[i] = n
+		logger.Infof("Migrated " + strconv.Itoa(n) + " entries of " + i)
+	}
+
+	/**** Customers of requests written before the customer index ****/
+	ids, err := get_index_ids(stub, applicationIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		b, err := t.get_brokerage_request_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		err = t.index_customer(stub, b)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(userRequestPrefix + b.Submitter + "|" + b.RequestID, []byte(b.RequestID))
+		if err != nil {
+			return nil, errors.New("Error adding " + b.RequestID + " to the requests of " + b.Submitter)
+		}
+	}
+	migrated[customerPrefix] = len(ids)
+	migrated[userRequestPrefix] = len(ids)
+
+	/**** Screenings recorded before the per-customer index ****/
+	_, values, err := range_by_prefix(stub, screeningPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		var r ScreeningResult
+		if json.Unmarshal(v, &r) != nil {
+			continue
+		}
+		err = stub.PutState(userScreeningPrefix + r.UserId + "|" + r.ScreeningId, []byte(r.ScreeningId))
+		if err != nil {
+			return nil, errors.New("Error adding screening to the screenings of " + r.UserId)
+		}
+	}
+	migrated[userScreeningPrefix] = len(values)
+
+	/**** Consents were keyed consent_<userId>_<accessorId>, which is ambiguous when either id has a _ ****/
+	keys, values, err := range_by_prefix(stub, consentPrefix)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for i := range keys {
+		var c KYCConsent
+		if json.Unmarshal(values[i], &c) != nil {
+			continue
+		}
+		key := consent_key(c.UserId, c.AccessorId)
+		if keys[i] == key {
+			continue
+		}
+		err = stub.PutState(key, values[i])
+		if err != nil {
+			return nil, errors.New("Error putting consent of " + c.UserId + " on ledger")
+		}
+		err = stub.DelState(keys[i])
+		if err != nil {
+			return nil, errors.New("Error deleting consent of " + c.UserId)
+		}
+		n++
+	}
+	migrated[consentPrefix] = n
+
+	err = t.write_audit(stub, "migrate_indexes", "indexes", migrated)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (t *SimpleChaincode) add_user(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		  index		user JSON object (as string)
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	err := check_record_id(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.check_user_json(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = create_record(stub, usersIndexStr, args[0], []byte(args[1]))
+	if err != nil {
+		return nil, errors.New("Error creating user " + args[0] + ". " + err.Error())
+	}
+
+	return []byte("1"), nil
+}
+
+func (t *SimpleChaincode) update_user(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1						2
+	//		  index		user JSON object (as string)	expected version
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	err := t.check_profile_owner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.check_user_json(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := t.get_user_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	var updated map[string]json.RawMessage
+	err = json.Unmarshal([]byte(args[1]), &updated)
+	if err != nil {
+		return nil, errors.New("Invalid user JSON")
+	}
+
+	/**** Fields left out keep their current value, userId included. The password is not changed by a user update. ****/
+	currentAsBytes, _ := json.Marshal(current)
+	var stored map[string]json.RawMessage
+	json.Unmarshal(currentAsBytes, &stored)
+	keep_unset_fields(updated, stored)
+	for _, field := range []string{"salt", "hash"} {
+		before, _ := canonical_json(stored[field])
+		after, _ := canonical_json(updated[field])
+		if string(before) != string(after) {
+			return nil, errors.New("Field " + field + " cannot be changed through update_user")
+		}
+	}
+
+	/**** Identity-bearing fields only change through review_profile_change ****/
+	version, err := t.write_profile_update(stub, current, updated, args[2])
+	if err != nil {
+		return nil, errors.New("Error updating user " + args[0] + ". " + err.Error())
+	}
+
+	return []byte(strconv.Itoa(version)), nil
+}
+
+//==============================================================================================================================
+//	 update_profile - Partial update of a user's profile by the user or an admin. Phone and email apply at once,
+//					  name, address and personal details become a pending change for a document verifier.
+//==============================================================================================================================
+func (t *SimpleChaincode) update_profile(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1										2
+	//		userId		JSON object of the fields to change		expected version
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	err := t.check_profile_owner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var patch map[string]json.RawMessage
+	err = json.Unmarshal([]byte(args[1]), &patch)
+	if err != nil {
+		return nil, errors.New("Invalid profile JSON")
+	}
+	for field := range patch {
+		if !contains_string(immediateProfileFields, field) && !contains_string(sensitiveProfileFields, field) {
+			return nil, errors.New("Field " + field + " cannot be changed through a profile update")
+		}
+	}
+
+	current, err := t.get_user_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	currentAsBytes, _ := json.Marshal(current)
+	var updated map[string]json.RawMessage
+	json.Unmarshal(currentAsBytes, &updated)
+	for field, value := range patch {
+		updated[field] = value
+	}
+
+	version, err := t.write_profile_update(stub, current, updated, args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strconv.Itoa(version)), nil
+}
+
+/**** Copies the stored value of every field the update leaves out ****/
+func keep_unset_fields(updated map[string]json.RawMessage, stored map[string]json.RawMessage) {
+	for field, value := range stored {
+		if _, given := updated[field]; !given {
+			updated[field] = value
+		}
+	}
+}
+
+/**** A user's profile is changed by the user or an admin ****/
+func (t *SimpleChaincode) check_profile_owner(stub *shim.ChaincodeStub, userId string) error {
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return err
+	}
+	role, _ := t.get_role(stub)
+	if username != userId && role != roleAdmin {
+		return errors.New("Permission denied. Only " + userId + " or an admin can update this profile")
+	}
+	return nil
+}
+
+/*
+	Writes the updated user with its sensitive fields kept at their current values, and records a pending change
+	for the sensitive fields that differ. The proposed user is validated as a whole first.
+*/
+func (t *SimpleChaincode) write_profile_update(stub *shim.ChaincodeStub, current User, updated map[string]json.RawMessage, expectedVersion string) (int, error) {
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return 0, err
+	}
+
+	updatedAsBytes, _ := json.Marshal(updated)
+	var proposed User
+	err = json.Unmarshal(updatedAsBytes, &proposed)
+	if err != nil {
+		return 0, errors.New("Invalid user JSON")
+	}
+	if proposed.UserId != current.UserId {
+		return 0, errors.New("userId cannot be changed")
+	}
+	err = validate_user(proposed, now)
+	if err != nil {
+		return 0, err
+	}
+
+	currentAsBytes, _ := json.Marshal(current)
+	var old map[string]json.RawMessage
+	json.Unmarshal(currentAsBytes, &old)
+
+	change := ProfileChange{UserId: current.UserId, NewValues: map[string]json.RawMessage{}, OldValues: map[string]json.RawMessage{}}
+	for _, field := range sensitiveProfileFields {
+		before, _ := canonical_json(old[field])
+		after, _ := canonical_json(updated[field])
+		if string(before) == string(after) {
+			continue
+		}
+		change.NewValues[field] = updated[field]
+		change.OldValues[field] = old[field]
+		if old[field] == nil {
+			delete(updated, field)
+		} else {
+			updated[field] = old[field]
+		}
+	}
+
+	valueAsBytes, _ := json.Marshal(updated)
+	version, err := update_record(stub, usersIndexStr, current.UserId, valueAsBytes, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(change.NewValues) == 0 {
+		return version, nil
+	}
+
+	changes, err := t.get_profile_change_list(stub, current.UserId)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range changes {
+		if c.Status == changePending {
+			return 0, errors.New(current.UserId + " already has a pending profile change " + c.ChangeId)
+		}
+	}
+
+	username, _ := t.get_username(stub)
+	change.ChangeId = stub.GetTxID()
+	change.Status = changePending
+	change.RequestedBy = username
+	change.RequestedAt = now.Format(time.RFC3339)
+	sealed, err := t.put_profile_change(stub, change)
+	if err != nil {
+		return 0, err
+	}
+
+	err = t.write_audit(stub, "request_profile_change", current.UserId, sealed)
+	if err != nil {
+		return 0, err
+	}
+	return version, t.notify(stub, current.UserId, "profile_change_pending", map[string]string{"ChangeId": change.ChangeId})
+}
+
+//==============================================================================================================================
+//	 review_profile_change - A document verifier approves or rejects a pending profile change. Approval applies the
+//							 new values, the old ones stay in the change and in the user's history.
+//==============================================================================================================================
+func (t *SimpleChaincode) review_profile_change(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2						3
+	//		userId		changeId	APPROVE or REJECT		comment
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+	if args[2] != decisionApprove && args[2] != decisionReject {
+		return nil, errors.New("Decision must be APPROVE or REJECT")
+	}
+
+	verifier, err := t.caller_accessor_with_role(stub, roleDocVerifier)
+	if err != nil {
+		return nil, errors.New("Permission denied. Only document verifiers can review profile changes")
+	}
+	if verifier.AccessorId == args[0] {
+		return nil, errors.New("Permission denied. Cannot review a change to your own profile")
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := stub.GetState(profileChangePrefix + args[0] + "|" + args[1])
+	if err != nil || bytes == nil {
+		return nil, errors.New("Profile change " + args[1] + " of " + args[0] + " does not exist")
+	}
+	var change ProfileChange
+	err = json.Unmarshal(bytes, &change)
+	if err != nil {
+		return nil, errors.New("Corrupt profile change " + args[1])
+	}
+	err = t.open_profile_change(stub, &change)
+	if err != nil {
+		return nil, err
+	}
+	if change.Status != changePending {
+		return nil, errors.New("Profile change " + args[1] + " has already been reviewed")
+	}
+
+	change.ReviewedBy = verifier.AccessorId
+	change.ReviewedAt = now.Format(time.RFC3339)
+	change.Comment = args[3]
+	change.Status = changeRejected
+
+	if args[2] == decisionApprove {
+		change.Status = changeApproved
+
+		current, err := t.get_user_struct(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		currentAsBytes, _ := json.Marshal(current)
+		var updated map[string]json.RawMessage
+		json.Unmarshal(currentAsBytes, &updated)
+		for field, value := range change.NewValues {
+			updated[field] = value
+		}
+
+		updatedAsBytes, _ := json.Marshal(updated)
+		var u User
+		err = json.Unmarshal(updatedAsBytes, &u)
+		if err != nil {
+			return nil, errors.New("Invalid user JSON")
+		}
+		err = validate_user(u, now)
+		if err != nil {
+			return nil, err
+		}
+		err = t.put_user_struct(stub, u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sealed, err := t.put_profile_change(stub, change)
+	if err != nil {
+		return nil, err
+	}
+	err = t.write_audit(stub, "review_profile_change", change.UserId, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	template := "profile_change_approved"
+	if change.Status == changeRejected {
+		template = "profile_change_rejected"
+	}
+	return nil, t.notify(stub, change.UserId, template, map[string]string{"ChangeId": change.ChangeId})
+}
+
+/*
+	Stores a profile change with the old and new values sealed under "privatechange_<userId>|<changeId>". The shared
+	record keeps which fields change, each with the hash of its value. Returns the change as stored, for the audit.
+*/
+func (t *SimpleChaincode) put_profile_change(stub *shim.ChaincodeStub, change ProfileChange) (ProfileChange, error) {
+
+	values := map[string]string{}
+	for field, value := range change.NewValues {
+		values["NewValues." + field] = string(value)
+	}
+	for field, value := range change.OldValues {
+		values["OldValues." + field] = string(value)
+	}
+	hashes, _, err := seal_fields(stub, privateChangePrefix + change.UserId + "|" + change.ChangeId, values)
+	if err != nil {
+		return change, err
+	}
+
+	sealed := change
+	sealed.NewValues = map[string]json.RawMessage{}
+	sealed.OldValues = map[string]json.RawMessage{}
+	for field := range change.NewValues {
+		sealed.NewValues[field], _ = json.Marshal(hashes["NewValues." + field])
+	}
+	for field := range change.OldValues {
+		sealed.OldValues[field], _ = json.Marshal(hashes["OldValues." + field])
+	}
+
+	bytes, _ := json.Marshal(sealed)
+	err = stub.PutState(profileChangePrefix + change.UserId + "|" + change.ChangeId, bytes)
+	if err != nil {
+		return change, errors.New("Error putting profile change on ledger")
+	}
+	return sealed, nil
+}
+
+/*
+	Fills the old and new values of a profile change back in from its restricted record. Changes requested before
+	sealing have none and already hold the values.
+*/
+func (t *SimpleChaincode) open_profile_change(stub *shim.ChaincodeStub, change *ProfileChange) error {
+
+	hashes := map[string]string{}
+	for field, value := range change.NewValues {
+		var hash string
+		json.Unmarshal(value, &hash)
+		hashes["NewValues." + field] = hash
+	}
+	for field, value := range change.OldValues {
+		var hash string
+		json.Unmarshal(value, &hash)
+		hashes["OldValues." + field] = hash
+	}
+
+	values, found, err := open_fields(stub, privateChangePrefix + change.UserId + "|" + change.ChangeId, hashes)
+	if err != nil || !found {
+		return err
+	}
+	for field := range change.NewValues {
+		change.NewValues[field] = raw_or_nil(values["NewValues." + field])
+	}
+	for field := range change.OldValues {
+		change.OldValues[field] = raw_or_nil(values["OldValues." + field])
+	}
+	return nil
+}
+
+/**** A field absent before the change was stored as null ****/
+func raw_or_nil(value string) json.RawMessage {
+	if value == "" {
+		return nil
+	}
+	return json.RawMessage(value)
+}
+
+func (t *SimpleChaincode) get_profile_change_list(stub *shim.ChaincodeStub, userId string) ([]ProfileChange, error) {
+
+	keys, values, err := range_by_prefix(stub, profileChangePrefix + userId + "|")
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []ProfileChange{}
+	for i := range keys {
+		var c ProfileChange
+		err = json.Unmarshal(values[i], &c)
+		if err != nil {
+			return nil, errors.New("Corrupt profile change " + keys[i])
+		}
+		err = t.open_profile_change(stub, &c)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func contains_string(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SimpleChaincode) add_thing(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	// args
+	// 		0			1
+	//	   index	   thing JSON object (as string)
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	err := check_record_id(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var thing Thing
+	err = json.Unmarshal([]byte(args[1]), &thing)
+	if err != nil {
+		return nil, errors.New("Invalid thing JSON")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// The caller owns the thing, only an admin may add one for another user
+	thing.Id = args[0]
+	if thing.Owner == "" {
+		thing.Owner = username
+	}
+	if thing.Owner != username {
+		role, _ := t.get_role(stub)
+		if role != roleAdmin {
+			return nil, errors.New("Permission denied. Only an admin can add a thing owned by " + thing.Owner)
+		}
+	}
+	thing.CreatedBy = username
+	thing.CreatedAt = now.Format(time.RFC3339)
+	thing.UpdatedBy = username
+	thing.UpdatedAt = thing.CreatedAt
+
+	owner, err := t.get_user_struct(stub, thing.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	thingAsBytes, _ := json.Marshal(thing)
+	err = create_record(stub, thingsIndexStr, args[0], thingAsBytes)
+	if err != nil {
+		return nil, errors.New("Error creating thing " + args[0] + ". " + err.Error())
+	}
+
+	owner.Things = append(owner.Things, thing.Id)
+	err = t.put_user_struct(stub, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte("1"), nil
+
+}
+
+func (t *SimpleChaincode) update_thing(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	// args
+	// 		0			1								2
+	//	   index	   thing JSON object (as string)	expected version
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	var update Thing
+	err := json.Unmarshal([]byte(args[1]), &update)
+	if err != nil {
+		return nil, errors.New("Invalid thing JSON")
+	}
+
+	thing, err := t.get_thing_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := t.get_role(stub)
+	if thing.Owner != username && role != roleAdmin {
+		return nil, errors.New("Permission denied. Only the owner of " + args[0] + " can update it")
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ownership only changes through transfer_thing
+	thing.Description = update.Description
+	thing.Attributes = update.Attributes
+	thing.UpdatedBy = username
+	thing.UpdatedAt = now.Format(time.RFC3339)
+
+	thingAsBytes, _ := json.Marshal(thing)
+	version, err := update_record(stub, thingsIndexStr, args[0], thingAsBytes, args[2])
+	if err != nil {
+		return nil, errors.New("Error updating thing " + args[0] + ". " + err.Error())
+	}
+
+	return []byte(strconv.Itoa(version)), nil
+}
+
+//==============================================================================================================================
+//	 transfer_thing - Moves a thing to another user. The thing and the Things lists of both users are written in the
+//					  same transaction. Only the current owner or an admin may do this.
+//==============================================================================================================================
+func (t *SimpleChaincode) transfer_thing(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	// args
+	// 		0			1
+	//	   thingId	   new owner's user ID
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	thing, err := t.get_thing_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := t.get_role(stub)
+	if thing.Owner != username && role != roleAdmin {
+		return nil, errors.New("Permission denied. Only the owner of " + args[0] + " can transfer it")
+	}
+	if thing.Owner == args[1] {
+		return nil, errors.New(args[0] + " is already owned by " + args[1])
+	}
+
+	newOwner, err := t.get_user_struct(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// A thing without an owner was added before ownership was tracked
+	if thing.Owner != "" {
+		oldOwner, err := t.get_user_struct(stub, thing.Owner)
+		if err != nil {
+			return nil, err
+		}
+
+		var remaining []string
+		for _, id := range oldOwner.Things {
+			if id != thing.Id {
+				remaining = append(remaining, id)
+			}
+		}
+		oldOwner.Things = remaining
+
+		err = t.put_user_struct(stub, oldOwner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newOwner.Things = append(newOwner.Things, thing.Id)
+	err = t.put_user_struct(stub, newOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	thing.Owner = newOwner.UserId
+	thing.UpdatedBy = username
+	thing.UpdatedAt = now.Format(time.RFC3339)
+
+	current, err := get_version(stub, thing.Id)
+	if err != nil {
+		return nil, err
+	}
+	thingAsBytes, _ := json.Marshal(thing)
+	_, err = update_record(stub, thingsIndexStr, thing.Id, thingAsBytes, strconv.Itoa(current))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) get_thing_struct(stub *shim.ChaincodeStub, thingId string) (Thing, error) {
+
+	var thing Thing
+
+	bytes, err := stub.GetState(thingId)
+	if err != nil {
+		return thing, errors.New("Error getting thing " + thingId + " from ledger")
+	}
+	if bytes == nil {
+		return thing, errors.New("Thing " + thingId + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &thing)
+	if err != nil {
+		return thing, errors.New("Corrupt thing " + thingId)
+	}
+	return thing, nil
+}
+
+func (t *SimpleChaincode) get_user_struct(stub *shim.ChaincodeStub, userId string) (User, error) {
+
+	u, err := t.get_stored_user(stub, userId)
+	if err != nil {
+		return u, err
+	}
+
+	if u.PersonalDetailsHash != "" {
+		values, found, err := open_fields(stub, privateUserPrefix + userId, map[string]string{"personalDetails": u.PersonalDetailsHash})
+		if err != nil {
+			return u, err
+		}
+		if found {
+			err = json.Unmarshal([]byte(values["personalDetails"]), &u.PersonalDetails)
+			if err != nil {
+				return u, errors.New("Corrupt personal details of " + userId)
+			}
+			u.PersonalDetailsHash = ""
+		}
+	}
+	return u, nil
+}
+
+/**** The user as the ledger holds it, with the hash of the personal details instead of them ****/
+func (t *SimpleChaincode) get_stored_user(stub *shim.ChaincodeStub, userId string) (User, error) {
+
+	var u User
+
+	bytes, err := stub.GetState(userId)
+	if err != nil {
+		return u, errors.New("Error getting user " + userId + " from ledger")
+	}
+	if bytes == nil {
+		return u, errors.New("User " + userId + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &u)
+	if err != nil {
+		return u, errors.New("Corrupt user " + userId)
+	}
+	if u.UserId == "" {
+		u.UserId = userId
+	}
+	return u, nil
+}
+
+/*
+	Writes a user changed by the chaincode itself, at its current version.
+*/
+func (t *SimpleChaincode) put_user_struct(stub *shim.ChaincodeStub, u User) error {
+
+	current, err := get_version(stub, u.UserId)
+	if err != nil {
+		return err
+	}
+
+	userAsBytes, _ := json.Marshal(u)
+	_, err = update_record(stub, usersIndexStr, u.UserId, userAsBytes, strconv.Itoa(current))
+	return err
+}
+
+func (t *SimpleChaincode) create_brokerage_request(stub *shim.ChaincodeStub, jsonData string) ([]byte, error) {
+
+	/**** Convert the incoming arguments from json to bytearray ****/
+	var bytesArray = []byte(jsonData)
+
+	/**** Copy the incoming json data to a struct b ****/
+	var b BrokerageRequest;
+	err := json.Unmarshal(bytesArray, &b)
+	if err != nil {
+		return nil, errors.New("Invalid brokerage request JSON")
+	}
+	err = check_record_id(b.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** The caller submits the request for themselves, to a broker other than themselves ****/
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	b.Submitter = username
+	err = t.check_request_approver(stub, b.Approver, b.Submitter)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** The validation report is only written by document-verification accessors, see add_document_check ****/
+	b.DocValidationReport = ""
+
+	/**** Every request starts as submitted, approval goes through update_brokerage_application ****/
+	b.Status = statusSubmitted
+
+	/**** Set by share_kyc_with_broker and close_brokerage_request only ****/
+	b.KYCPackageRef = ""
+	b.Closure = nil
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+	details, err := normalize_request_details(&b, now)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_request_entity(stub, b, details)
+	if err != nil {
+		return nil, err
+	}
+
+	/****  Insert the details of the Brokerage application into a new row in the Table structure ****/
+	b.TimeStamps = submit_time_stamps(now)
+	err = t.insert_brokerage_request(stub, b)
+	if err != nil {
+		return nil, err
+	}
+	
+	/*Returning nil response for now*/
+	return nil, nil
+}
+
+func (t *SimpleChaincode) update_brokerage_application(stub *shim.ChaincodeStub, jsonData string) ([]byte, error) {
+
+	/****New Data to be written****/
+	var bytesArray = []byte(jsonData)
+
+	var inputBrokerageRequest BrokerageRequest
+	err := json.Unmarshal(bytesArray, &inputBrokerageRequest)
+	if err != nil {
+		return nil, errors.New("Invalid brokerage request JSON")
+	}
+
+	/****First get the data stored****/
+	brokerageRequest, err := t.get_brokerage_request_struct(stub, inputBrokerageRequest.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeStampJson []byte
+
+	updateType := inputBrokerageRequest.UpdateType
+	if updateType != "MEETING" && updateType != "VIDEO" && updateType != "STATUS" {
+		return nil, errors.New("Unknown UpdateType " + updateType + ", expecting MEETING, VIDEO or STATUS")
+	}
+
+	/**** A closed request keeps its meeting and video as they were, status changes check this in check_status_guards ****/
+	if updateType != "STATUS" && brokerageRequest.Closure != nil {
+		return nil, errors.New("Brokerage request " + brokerageRequest.RequestID + " is closed")
+	}
+
+	/**** Meetings and videos are arranged by the parties, the status is changed by the approver ****/
+	if updateType != "STATUS" {
+		username, err := t.get_username(stub)
+		if err != nil {
+			return nil, err
+		}
+		if username != brokerageRequest.Submitter && username != brokerageRequest.Approver {
+			return nil, errors.New("Permission denied. Only the submitter or approver of " + brokerageRequest.RequestID + " can update its " + strings.ToLower(updateType))
+		}
+	}
+
+	if updateType == "MEETING" {
+		brokerageRequest.Meeting = jsonData
+		/**** The submit time stays, it is what the review SLA counts from ****/
+		now, err := t.get_tx_time(stub)
+		if err != nil {
+			return nil, err
+		}
+		var stamps BrokerageRequestTimeStamp
+		if brokerageRequest.TimeStamps != "" && json.Unmarshal([]byte(brokerageRequest.TimeStamps), &stamps) != nil {
+			return nil, errors.New("Corrupt TimeStamps of " + brokerageRequest.RequestID)
+		}
+		stamps.MeetingConfirmation = now.Format(time.UnixDate)
+		timeStampJson, _ = json.Marshal(stamps)
+		brokerageRequest.TimeStamps = string(timeStampJson)
+	}else if updateType == "VIDEO" {
+		brokerageRequest.Video = jsonData
+	}else if updateType == "STATUS"{
+		err := t.check_status_transition(stub, brokerageRequest, inputBrokerageRequest.Status)
+		if err != nil {
+			return nil, err
+		}
+		/**** Only the status value is stored, not the update JSON, see stored_status ****/
+		brokerageRequest.Status = inputBrokerageRequest.Status
+	}
+	
+	/**** Store the data ****/
+	ok, err := stub.ReplaceRow("BrokerageRequests", brokerage_row(brokerageRequest))
+	if err != nil {
+		return nil, errors.New("Error updating brokerage request " + brokerageRequest.RequestID + ". " + err.Error())
+	}
+	if !ok {
+		return nil, errors.New("Brokerage request " + brokerageRequest.RequestID + " does not exist")
+	}
+
+	requestAsBytes, _ := json.Marshal(brokerageRequest)
+	err = append_history(stub, applicationIndexStr, brokerageRequest.RequestID, requestAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if updateType == "STATUS" {
+		err = t.complete_status_change(stub, brokerageRequest)
+	} else if updateType == "MEETING" {
+		params := map[string]string{"RequestID": brokerageRequest.RequestID}
+		err = t.notify(stub, brokerageRequest.Submitter, "meeting_scheduled", params)
+		if err == nil {
+			err = t.notify(stub, brokerageRequest.Approver, "meeting_scheduled", params)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return timeStampJson, nil
+}
+
+//==============================================================================================================================
+//	 register_accessor - Registers a Broker, Govt agency, Regulator, etc. together with the roles it may act in.
+//						 Only callers with the admin role may do this.
+//==============================================================================================================================
+func (t *SimpleChaincode) register_accessor(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		accessor JSON object (as string)
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can register accessors")
+	}
+
+	var a KyckAccessor
+	err = json.Unmarshal([]byte(args[0]), &a)
+	if err != nil {
+		return nil, errors.New("Invalid accessor JSON")
+	}
+	if a.AccessorId == "" {
+		return nil, errors.New("AccessorId is required")
+	}
+	if a.Organisation != "" {
+		org, err := stub.GetState(orgPrefix + a.Organisation)
+		if err != nil || org == nil {
+			return nil, errors.New("Organisation " + a.Organisation + " does not exist")
+		}
+	}
+
+	bytes, _ := json.Marshal(a)
+	err = stub.PutState(accessorPrefix + a.AccessorId, bytes)
+	if err != nil {
+		return nil, errors.New("Error putting accessor data on ledger")
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 add_document_check - Attaches the result of checking one document to a brokerage request. Only registered
+//						  document-verification accessors may call this. The per-document verdict and the aggregate
+//						  verdict are computed here, whatever the caller sends in those fields is ignored.
+//==============================================================================================================================
+func (t *SimpleChaincode) add_document_check(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0					1
+	//		requestId		document check JSON object (as string)
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	accessor, err := t.caller_accessor_with_role(stub, roleDocVerifier)
+	if err != nil {
+		return nil, errors.New("Permission denied. " + err.Error())
+	}
+
+	brokerageRequest, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var check DocumentCheck
+	err = json.Unmarshal([]byte(args[1]), &check)
+	if err != nil {
+		return nil, errors.New("Invalid document check JSON")
+	}
+	if check.DocumentId == "" {
+		return nil, errors.New("DocumentId is required")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	check.CheckedBy = accessor.AccessorId
+	check.CheckedAt = now.Format(time.RFC3339)
+	check.Verdict = document_check_verdict(check, now)
+
+	/**** Start from the stored report, a re-check of the same document replaces the earlier one ****/
+	var report DocumentValidationReport
+	if brokerageRequest.DocValidationReport != "" {
+		json.Unmarshal([]byte(brokerageRequest.DocValidationReport), &report)
+	}
+	report.RequestID = brokerageRequest.RequestID
+
+	replaced := false
+	for i := range report.Checks {
+		if report.Checks[i].DocumentId == check.DocumentId {
+			report.Checks[i] = check
+			replaced = true
+		}
+	}
+	if !replaced {
+		report.Checks = append(report.Checks, check)
+	}
+
+	report.Verdict = aggregate_verdict(report.Checks)
+	report.UpdatedAt = check.CheckedAt
+
+	reportAsBytes, _ := json.Marshal(report)
+	brokerageRequest.DocValidationReport = string(reportAsBytes)
+
+	err = t.replace_brokerage_request(stub, brokerageRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return reportAsBytes, nil
+}
+
+//==============================================================================================================================
+//	 store_kyc_package - Stores the KYC data of an approved brokerage request once against the customer's KyckUser,
+//						 so it can be shared with other brokers. Only the approver of the request may do this.
+//==============================================================================================================================
+func (t *SimpleChaincode) store_kyc_package(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		requestId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	brokerageRequest, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if brokerageRequest.Approver != username {
+		return nil, errors.New("Permission denied. Only the approver of " + args[0] + " can store its KYC package")
+	}
+	err = t.open_payload(stub, &brokerageRequest)
+	if err != nil {
+		return nil, err
+	}
+	if brokerageRequest.Status != statusApproved {
+		return nil, errors.New("Brokerage request " + args[0] + " is not approved")
+	}
+	if brokerageRequest.DocVerdict != verdictPass {
+		return nil, errors.New("Documents of " + args[0] + " did not pass verification, verdict is " + brokerageRequest.DocVerdict)
+	}
+	if brokerageRequest.KYCPackageRef != "" {
+		return nil, errors.New("Brokerage request " + args[0] + " already uses a shared KYC package")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Keep the profile fields of an earlier package, the verified data is replaced ****/
+	k, err := t.get_kyck_user_struct(stub, brokerageRequest.Submitter)
+	if err != nil {
+		k = KyckUser{UserId: brokerageRequest.Submitter}
+	}
+
+	k.Documents = []byte(brokerageRequest.Documents)
+	k.PersonalDetails = []byte(brokerageRequest.PersonalDetails)
+	k.KYCDetails = []byte(brokerageRequest.KYCDetails)
+	k.DocValidationReport = []byte(brokerageRequest.DocValidationReport)
+	k.Verified = true
+	k.VerifiedBy = username
+	k.SourceRequestID = brokerageRequest.RequestID
+	k.TimeStamp = now.Format(time.RFC3339)
+
+	/**** The risk category of the latest assessment picks the review interval ****/
+	assessment, err := t.get_risk_assessment(stub, brokerageRequest.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	k.EDDApprovedBy = ""
+	if assessment != nil {
+		k.RiskScore = assessment.Score
+		k.RiskCategory = assessment.Category
+		k.RiskAssessment = assessment
+	}
+
+	/**** Enhanced due diligence counts once a compliance officer approved it under the approval policy ****/
+	if assessment != nil && assessment.EDDRequired {
+		status, err := t.approval_status(stub, brokerageRequest)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range status.Decisions {
+			if d.Decision == decisionApprove && contains_string(d.Roles, roleComplianceOfficer) {
+				k.EDDApprovedBy = d.Reviewer
+			}
+		}
+	}
+
+	err = t.schedule_review(stub, &k, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.put_kyck_user(stub, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 share_kyc_with_broker - Called by the customer. Records their consent and creates a brokerage request for the
+//							 broker that references the stored KYC package instead of re-uploading it.
+//==============================================================================================================================
+func (t *SimpleChaincode) share_kyc_with_broker(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		requestId		brokerId
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	requestId := args[0]
+	brokerId := args[1]
+	err := check_record_id(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := t.get_kyck_user_struct(stub, username)
+	if err != nil {
+		return nil, err
+	}
+	if !k.Verified {
+		return nil, errors.New("KYC package of " + username + " is not verified")
+	}
+
+	if brokerId == "" {
+		return nil, errors.New("Broker is required")
+	}
+	err = t.check_request_approver(stub, brokerId, username)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+	if kyc_expired(k.KYCExpiryDate, now.Format(dateLayout)) {
+		return nil, errors.New("KYC package of " + username + " expired on " + k.KYCExpiryDate + " and needs re-verification")
+	}
+
+	var b BrokerageRequest
+	b.RequestID = requestId
+	b.Submitter = username
+	b.Approver = brokerId
+	b.Status = statusSubmitted
+	b.TimeStamps = submit_time_stamps(now)
+	b.KYCPackageRef = username
+
+	err = t.insert_brokerage_request(stub, b)
+	if err != nil {
+		return nil, err
+	}
+
+	consent := KYCConsent{
+		UserId:     username,
+		AccessorId: brokerId,
+		RequestID:  requestId,
+		Active:     true,
+		GrantedAt:  now.Format(time.RFC3339),
+	}
+	consentAsBytes, _ := json.Marshal(consent)
+	err = stub.PutState(consent_key(username, brokerId), consentAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting consent on ledger")
+	}
+
+	return []byte(requestId), nil
+}
+
+//==============================================================================================================================
+//	 revoke_kyc_consent - Called by the customer to stop a broker reading their shared KYC package
+//==============================================================================================================================
+func (t *SimpleChaincode) revoke_kyc_consent(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		brokerId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	key := consent_key(username, args[0])
+	bytes, err := stub.GetState(key)
+	if err != nil || bytes == nil {
+		return nil, errors.New("No consent given to " + args[0])
+	}
+
+	var consent KYCConsent
+	json.Unmarshal(bytes, &consent)
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	consent.Active = false
+	consent.RevokedAt = now.Format(time.RFC3339)
+
+	consentAsBytes, _ := json.Marshal(consent)
+	err = stub.PutState(key, consentAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting consent on ledger")
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 set_review_policy - Sets the months between periodic reviews per risk category. Admin only.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_review_policy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		policy JSON object, e.g. {"LOW":36,"MEDIUM":24,"HIGH":12}
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can set the review policy")
+	}
+
+	var policy map[string]int
+	err = json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil {
+		return nil, errors.New("Invalid review policy JSON")
+	}
+	for _, category := range []string{riskLow, riskMedium, riskHigh} {
+		if policy[category] <= 0 {
+			return nil, errors.New("Review interval for " + category + " must be a positive number of months")
+		}
+	}
+
+	policyAsBytes, _ := json.Marshal(policy)
+	err = stub.PutState(reviewPolicyStr, policyAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting review policy on ledger")
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) get_review_policy(stub *shim.ChaincodeStub) (map[string]int, error) {
+
+	bytes, err := stub.GetState(reviewPolicyStr)
+	if err != nil {
+		return nil, errors.New("Failed to get " + reviewPolicyStr)
+	}
+	if bytes == nil {
+		return defaultReviewPolicy, nil
+	}
+
+	var policy map[string]int
+	err = json.Unmarshal(bytes, &policy)
+	if err != nil {
+		return nil, errors.New("Corrupt review policy")
+	}
+	return policy, nil
+}
+
+/*
+	Sets the document expiries, review interval and overall expiry of a freshly verified KYC package. The package
+	expires after the review interval of its risk category, or earlier when one of its documents expires first.
+*/
+func (t *SimpleChaincode) schedule_review(stub *shim.ChaincodeStub, k *KyckUser, verifiedAt time.Time) error {
+
+	policy, err := t.get_review_policy(stub)
+	if err != nil {
+		return err
+	}
+
+	if k.RiskCategory == "" {
+		k.RiskCategory = riskMedium
+	}
+	k.ReviewIntervalMonths = policy[k.RiskCategory]
+	if k.ReviewIntervalMonths <= 0 {
+		return errors.New("No review interval configured for risk category " + k.RiskCategory)
+	}
+
+	expiry := verifiedAt.AddDate(0, k.ReviewIntervalMonths, 0).Format(dateLayout)
+
+	k.DocumentExpiries = nil
+	var report DocumentValidationReport
+	if len(k.DocValidationReport) > 0 {
+		json.Unmarshal(k.DocValidationReport, &report)
+	}
+	for _, c := range report.Checks {
+		if c.ExpiryDate == "" {
+			continue
+		}
+		k.DocumentExpiries = append(k.DocumentExpiries, DocumentExpiry{DocumentId: c.DocumentId, DocumentType: c.DocumentType, ExpiryDate: c.ExpiryDate})
+		if c.ExpiryDate < expiry {
+			expiry = c.ExpiryDate
+		}
+	}
+
+	k.KYCExpiryDate = expiry
+	return nil
+}
+
+//==============================================================================================================================
+//	 set_risk_model - Replaces the customer risk model. Admin only. The version is bumped so assessments record
+//					  which model produced them.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_risk_model(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		risk model JSON object (as string)
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can set the risk model")
+	}
+
+	var model RiskModel
+	err = json.Unmarshal([]byte(args[0]), &model)
+	if err != nil {
+		return nil, errors.New("Invalid risk model JSON")
+	}
+	if model.MediumThreshold <= 0 || model.HighThreshold <= model.MediumThreshold {
+		return nil, errors.New("Risk model needs 0 < MediumThreshold < HighThreshold")
+	}
+
+	current, err := t.get_risk_model(stub)
+	if err == nil {
+		model.Version = current.Version + 1
+	} else {
+		model.Version = 1
+	}
+
+	modelAsBytes, _ := json.Marshal(model)
+	err = stub.PutState(riskModelStr, modelAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting risk model on ledger")
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) get_risk_model(stub *shim.ChaincodeStub) (RiskModel, error) {
+
+	var model RiskModel
+
+	bytes, err := stub.GetState(riskModelStr)
+	if err != nil {
+		return model, errors.New("Failed to get " + riskModelStr)
+	}
+	if bytes == nil {
+		return model, errors.New("No risk model configured")
+	}
+
+	err = json.Unmarshal(bytes, &model)
+	if err != nil {
+		return model, errors.New("Corrupt risk model")
+	}
+	return model, nil
+}
+
+//==============================================================================================================================
+//	 assess_risk - Scores the customer of a brokerage request against the current risk model and stores the score,
+//				   category and the inputs used. Only the approver of the request may do this, while it is open
+//				   and before any approval decision. The approver supplies the product and volume, the country,
+//				   occupation and PEP flag come from the request and the latest screening.
+//==============================================================================================================================
+func (t *SimpleChaincode) assess_risk(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		requestId		risk inputs JSON object (as string)
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	brokerageRequest, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if brokerageRequest.Approver != username {
+		return nil, errors.New("Permission denied. Only the approver of " + args[0] + " can assess its risk")
+	}
+	if brokerageRequest.Closure != nil || !is_open_status(brokerageRequest.Status) {
+		return nil, errors.New("Brokerage request " + args[0] + " is already decided")
+	}
+
+	/**** The assessment picks the approval policy, it is frozen once reviewers have started deciding on it ****/
+	decisions, _, err := range_by_prefix(stub, approvalDecisionPrefix + brokerageRequest.RequestID + "|")
+	if err != nil {
+		return nil, err
+	}
+	if len(decisions) > 0 {
+		return nil, errors.New("Risk of " + args[0] + " cannot be reassessed, approval decisions are already recorded")
+	}
+
+	var inputs RiskInputs
+	err = json.Unmarshal([]byte(args[1]), &inputs)
+	if err != nil {
+		return nil, errors.New("Invalid risk inputs JSON")
+	}
+
+	model, err := t.get_risk_model(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Only the product and the expected volume come from the approver, the rest from the request and the screening ****/
+	countries, occupation, err := t.request_risk_facts(stub, brokerageRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(countries) == 0 {
+		return nil, errors.New("Brokerage request " + args[0] + " names no country to assess")
+	}
+	inputs.Country = riskiest_country(model, countries)
+	if occupation != "" {
+		inputs.Occupation = occupation
+	}
+	inputs.PEP, err = t.screening_pep(stub, brokerageRequest.Submitter)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	assessment := score_risk(model, inputs)
+	assessment.RequestID = brokerageRequest.RequestID
+	assessment.AssessedBy = username
+	assessment.AssessedAt = now.Format(time.RFC3339)
+
+	assessmentAsBytes, _ := json.Marshal(assessment)
+	err = stub.PutState(riskPrefix + brokerageRequest.RequestID, assessmentAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting risk assessment on ledger")
+	}
+
+	return assessmentAsBytes, nil
+}
+
+/*
+	Countries and occupation the customer gave in the request, or in the shared KYC package it refers to: nationality,
+	incorporation country, address countries and tax residencies.
+*/
+func (t *SimpleChaincode) request_risk_facts(stub *shim.ChaincodeStub, b BrokerageRequest) ([]string, string, error) {
+
+	var personalJson, kycJson string
+	if b.KYCPackageRef != "" {
+		k, err := t.get_kyck_user_struct(stub, b.KYCPackageRef)
+		if err != nil {
+			return nil, "", err
+		}
+		personalJson = string(k.PersonalDetails)
+		kycJson = string(k.KYCDetails)
+	} else {
+		err := t.open_payload(stub, &b)
+		if err != nil {
+			return nil, "", err
+		}
+		personalJson = b.PersonalDetails
+		kycJson = b.KYCDetails
+	}
+
+	var details PersonalDetails
+	var kyc KYCDetails
+	if personalJson != "" && json.Unmarshal([]byte(personalJson), &details) != nil {
+		return nil, "", errors.New("PersonalDetails of " + b.RequestID + " are not readable")
+	}
+	if kycJson != "" && json.Unmarshal([]byte(kycJson), &kyc) != nil {
+		return nil, "", errors.New("KYCDetails of " + b.RequestID + " are not readable")
+	}
+
+	var countries []string
+	for _, c := range []string{details.Nationality, details.IncorporationCountry} {
+		if c != "" {
+			countries = append(countries, c)
+		}
+	}
+	for _, a := range details.Addresses {
+		if a.Country != "" {
+			countries = append(countries, a.Country)
+		}
+	}
+	for _, r := range kyc.TaxResidencies {
+		if r.Country != "" {
+			countries = append(countries, r.Country)
+		}
+	}
+	return countries, kyc.Occupation, nil
+}
+
+/**** The country scoring the most points, first one on a tie ****/
+func riskiest_country(model RiskModel, countries []string) string {
+
+	riskiest := ""
+	best := 0
+	for _, c := range countries {
+		points, ok := model.CountryPoints[c]
+		if !ok {
+			points = model.DefaultPoints
+		}
+		if riskiest == "" || points > best {
+			riskiest = c
+			best = points
+		}
+	}
+	return riskiest
+}
+
+/*
+	Adds up the points of every factor. Enhanced due diligence is required for HIGH risk and for every PEP.
+*/
+func score_risk(model RiskModel, inputs RiskInputs) RiskAssessment {
+
+	lookup := func(points map[string]int, key string) int {
+		if p, ok := points[key]; ok {
+			return p
+		}
+		return model.DefaultPoints
+	}
+
+	var a RiskAssessment
+	a.Inputs = inputs
+	a.ModelVersion = model.Version
+	a.Points = map[string]int{
+		"Country":           lookup(model.CountryPoints, inputs.Country),
+		"Occupation":        lookup(model.OccupationPoints, inputs.Occupation),
+		"ProductType":       lookup(model.ProductPoints, inputs.ProductType),
+		"PEP":               0,
+		"TransactionVolume": 0,
+	}
+	if inputs.PEP {
+		a.Points["PEP"] = model.PEPPoints
+	}
+	for _, band := range model.VolumeBands {
+		if inputs.TransactionVolume >= band.Min && (band.Max == 0 || inputs.TransactionVolume < band.Max) {
+			a.Points["TransactionVolume"] = band.Points
+			break
+		}
+	}
+
+	for _, p := range a.Points {
+		a.Score += p
+	}
+
+	a.Category = riskLow
+	if a.Score >= model.HighThreshold {
+		a.Category = riskHigh
+	} else if a.Score >= model.MediumThreshold {
+		a.Category = riskMedium
+	}
+	a.EDDRequired = a.Category == riskHigh || inputs.PEP
+
+	return a
+}
+
+/*
+	Returns the stored risk assessment of a brokerage request, nil if it has not been assessed yet.
+*/
+func (t *SimpleChaincode) get_risk_assessment(stub *shim.ChaincodeStub, requestId string) (*RiskAssessment, error) {
+
+	bytes, err := stub.GetState(riskPrefix + requestId)
+	if err != nil {
+		return nil, errors.New("Failed to get risk assessment of " + requestId)
+	}
+	if bytes == nil {
+		return nil, nil
+	}
+
+	var a RiskAssessment
+	err = json.Unmarshal(bytes, &a)
+	if err != nil {
+		return nil, errors.New("Corrupt risk assessment of " + requestId)
+	}
+	return &a, nil
+}
+
+//==============================================================================================================================
+//	 check_status_transition - Only the approver may change the status, only along statusTransitions, and a request
+//							   is only approved once the submitter has a cleared sanctions/PEP screening and the approval
+//							   policy of its risk category, if any, is satisfied.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_status_transition(stub *shim.ChaincodeStub, b BrokerageRequest, newStatus string) error {
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return err
+	}
+	if b.Approver != username {
+		return errors.New("Permission denied. Only the approver of " + b.RequestID + " can change its status")
+	}
+
+	err = t.check_status_guards(stub, b, newStatus)
+	if err != nil {
+		return err
+	}
+
+	/**** Requests under an approval policy are approved by record_approval_decision ****/
+	if newStatus == statusApproved {
+		status, err := t.approval_status(stub, b)
+		if err != nil {
+			return err
+		}
+		if !status.Satisfied {
+			return errors.New("Cannot approve " + b.RequestID + ", approval policy not satisfied. Missing: " + strings.Join(status.Missing, ", "))
+		}
+	}
+
+	return nil
+}
+
+/*
+	Checks that hold whoever moves the request: it is open, the move follows statusTransitions, and an approval
+	needs a risk assessment, a cleared screening and, for corporate requests, see check_entity_for_approval.
+*/
+func (t *SimpleChaincode) check_status_guards(stub *shim.ChaincodeStub, b BrokerageRequest, newStatus string) error {
+
+	if b.Closure != nil {
+		return errors.New("Brokerage request " + b.RequestID + " is closed")
+	}
+
+	current := b.Status
+	if current == "" {
+		current = statusSubmitted
+	}
+	if !status_move_allowed(current, newStatus) {
+		return errors.New("Cannot change status of " + b.RequestID + " from " + current + " to " + newStatus)
+	}
+
+	if newStatus == statusApproved {
+		/**** The assessment picks the approval policy, without one there would be nothing to satisfy ****/
+		assessment, err := t.get_risk_assessment(stub, b.RequestID)
+		if err != nil {
+			return err
+		}
+		if assessment == nil {
+			return errors.New("Cannot approve " + b.RequestID + " before its risk is assessed")
+		}
+
+		cleared, err := t.screening_cleared(stub, b.Submitter)
+		if err != nil {
+			return err
+		}
+		if !cleared {
+			return errors.New("Cannot approve " + b.RequestID + " without a cleared sanctions/PEP screening of " + b.Submitter)
+		}
+
+		if b.EntityId != "" {
+			err = t.check_entity_for_approval(stub, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 record_screening - Records the sanctions/PEP screening of a customer. Only registered screening providers may
+//						do this. A screening without matches is cleared, otherwise it waits for a compliance officer.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_screening(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0						1
+	//		userId		screening result JSON object (as string)
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	provider, err := t.caller_accessor_with_role(stub, roleScreeningProvider)
+	if err != nil {
+		return nil, errors.New("Permission denied. " + err.Error())
+	}
+
+	var result ScreeningResult
+	err = json.Unmarshal([]byte(args[1]), &result)
+	if err != nil {
+		return nil, errors.New("Invalid screening result JSON")
+	}
+	if len(result.ListsChecked) == 0 {
+		return nil, errors.New("A screening must name the lists checked")
+	}
+	for _, l := range result.ListsChecked {
+		if l.Name == "" || l.Version == "" {
+			return nil, errors.New("Every list checked needs a name and a version")
+		}
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	result.ScreeningId = stub.GetTxID()
+	result.UserId = args[0]
+	result.Provider = provider.AccessorId
+	result.ScreenedAt = now.Format(time.RFC3339)
+	result.DispositionBy = ""
+	result.DispositionAt = ""
+	result.DispositionComment = ""
+	if len(result.Matches) == 0 {
+		result.Disposition = screeningNoMatch
+	} else {
+		result.Disposition = screeningPendingReview
+	}
+
+	err = t.put_screening(stub, result)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(userScreeningPrefix + result.UserId + "|" + result.ScreeningId, []byte(result.ScreeningId))
+	if err != nil {
+		return nil, errors.New("Error adding screening to the screenings of " + result.UserId)
+	}
+
+	/**** Approval looks at the latest screening only ****/
+	err = stub.PutState(latestScreeningPrefix + result.UserId, []byte(result.ScreeningId))
+	if err != nil {
+		return nil, errors.New("Error putting latest screening on ledger")
+	}
+
+	return []byte(result.ScreeningId), nil
+}
+
+//==============================================================================================================================
+//	 disposition_screening - A compliance officer clears the matches of a screening or confirms a true match
+//==============================================================================================================================
+func (t *SimpleChaincode) disposition_screening(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1				2
+	//		screeningId		disposition		comment
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	officer, err := t.caller_accessor_with_role(stub, roleComplianceOfficer)
+	if err != nil {
+		return nil, errors.New("Permission denied. " + err.Error())
+	}
+
+	if args[1] != screeningCleared && args[1] != screeningTrueMatch {
+		return nil, errors.New("Disposition must be " + screeningCleared + " or " + screeningTrueMatch)
+	}
+	if args[2] == "" {
+		return nil, errors.New("A disposition needs a comment")
+	}
+
+	result, err := t.get_screening_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if result.Disposition != screeningPendingReview {
+		return nil, errors.New("Screening " + args[0] + " is not waiting for review")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Disposition = args[1]
+	result.DispositionBy = officer.AccessorId
+	result.DispositionAt = now.Format(time.RFC3339)
+	result.DispositionComment = args[2]
+
+	err = t.put_screening(stub, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) get_screening_struct(stub *shim.ChaincodeStub, screeningId string) (ScreeningResult, error) {
+
+	var result ScreeningResult
+
+	bytes, err := stub.GetState(screeningPrefix + screeningId)
+	if err != nil {
+		return result, errors.New("Error getting screening " + screeningId + " from ledger")
+	}
+	if bytes == nil {
+		return result, errors.New("Screening " + screeningId + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &result)
+	if err != nil {
+		return result, errors.New("Corrupt screening " + screeningId)
+	}
+	return result, nil
+}
+
+func (t *SimpleChaincode) put_screening(stub *shim.ChaincodeStub, result ScreeningResult) error {
+
+	bytes, _ := json.Marshal(result)
+	err := stub.PutState(screeningPrefix + result.ScreeningId, bytes)
+	if err != nil {
+		return errors.New("Error putting screening on ledger")
+	}
+	return nil
+}
+
+/*
+	True when the latest screening of the user found nothing, had its matches cleared, or only confirmed matches on
+	PEP lists. A politically exposed person is not barred, their risk assessment requires enhanced due diligence.
+*/
+func (t *SimpleChaincode) screening_cleared(stub *shim.ChaincodeStub, userId string) (bool, error) {
+
+	result, found, err := t.latest_screening(stub, userId)
+	if err != nil || !found {
+		return false, err
+	}
+	if result.Disposition == screeningTrueMatch {
+		return only_pep_matches(result), nil
+	}
+	return result.Disposition == screeningNoMatch || result.Disposition == screeningCleared, nil
+}
+
+/*
+	True when the latest screening of the user has matches on a PEP list that were not dismissed as false positives.
+*/
+func (t *SimpleChaincode) screening_pep(stub *shim.ChaincodeStub, userId string) (bool, error) {
+
+	result, found, err := t.latest_screening(stub, userId)
+	if err != nil || !found || result.Disposition == screeningCleared {
+		return false, err
+	}
+	for _, m := range result.Matches {
+		if is_pep_list(m.List) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *SimpleChaincode) latest_screening(stub *shim.ChaincodeStub, userId string) (ScreeningResult, bool, error) {
+
+	screeningId, err := stub.GetState(latestScreeningPrefix + userId)
+	if err != nil {
+		return ScreeningResult{}, false, errors.New("Failed to get latest screening of " + userId)
+	}
+	if screeningId == nil {
+		return ScreeningResult{}, false, nil
+	}
+
+	result, err := t.get_screening_struct(stub, string(screeningId))
+	if err != nil {
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+func is_pep_list(name string) bool {
+	return strings.Contains(strings.ToUpper(name), "PEP")
+}
+
+func only_pep_matches(result ScreeningResult) bool {
+	for _, m := range result.Matches {
+		if !is_pep_list(m.List) {
+			return false
+		}
+	}
+	return len(result.Matches) > 0
+}
+
+//==============================================================================================================================
+//	 close_brokerage_request - Withdraw (by the submitter, before a decision) or archive (by the approver or an admin).
+//							   The row stays, it is hidden from default listings and its payload columns are purged
+//							   by purge_expired_requests once the retention period has passed.
+//==============================================================================================================================
+func (t *SimpleChaincode) close_brokerage_request(stub *shim.ChaincodeStub, args []string, state string) ([]byte, error) {
+
+	//Args
+	//			0			1
+	//		requestId	reason
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return nil, errors.New("A reason is required")
+	}
+
+	b, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if b.Closure != nil {
+		return nil, errors.New("Brokerage request " + args[0] + " is already closed")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := t.get_role(stub)
+
+	if state == closureWithdrawn {
+		if b.Submitter != username {
+			return nil, errors.New("Permission denied. Only the submitter of " + args[0] + " can withdraw it")
+		}
+		if b.Status == statusApproved || b.Status == statusRejected {
+			return nil, errors.New("Brokerage request " + args[0] + " has been decided and can no longer be withdrawn")
+		}
+		b.Status = statusWithdrawn
+	} else if b.Approver != username && role != roleAdmin {
+		return nil, errors.New("Permission denied. Only the approver of " + args[0] + " or an admin can archive it")
+	}
+
+	policy, err := t.get_retention_policy(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Closure = &RequestClosure{
+		State:      state,
+		Reason:     args[1],
+		ClosedBy:   username,
+		ClosedAt:   now.Format(time.RFC3339),
+		PurgeAfter: now.AddDate(0, 0, policy.RetentionDays).Format(dateLayout),
+	}
+
+	err = t.replace_brokerage_request(stub, b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.write_audit(stub, "close_brokerage_request", b.RequestID, b.Closure)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]string{"RequestID": b.RequestID, "Reason": args[1]}
+	if state == closureWithdrawn {
+		err = t.notify(stub, b.Approver, "request_withdrawn", params)
+	} else {
+		err = t.notify(stub, b.Submitter, "request_archived", params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 set_retention_policy - Days a closed brokerage request keeps its payload. Admin only. Applies to requests
+//							closed from now on.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_retention_policy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		retention policy JSON object, e.g. {"RetentionDays":1825}
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can set the retention policy")
+	}
+
+	var policy RetentionPolicy
+	err = json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil || policy.RetentionDays < 0 {
+		return nil, errors.New("Invalid retention policy JSON")
+	}
+
+	policyAsBytes, _ := json.Marshal(policy)
+	err = stub.PutState(retentionPolicyStr, policyAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting retention policy on ledger")
+	}
+
+	return nil, t.write_audit(stub, "set_retention_policy", "retention", policy)
+}
+
+func (t *SimpleChaincode) get_retention_policy(stub *shim.ChaincodeStub) (RetentionPolicy, error) {
+
+	bytes, err := stub.GetState(retentionPolicyStr)
+	if err != nil {
+		return RetentionPolicy{}, errors.New("Failed to get " + retentionPolicyStr)
+	}
+	if bytes == nil {
+		return defaultRetentionPolicy, nil
+	}
+
+	var policy RetentionPolicy
+	err = json.Unmarshal(bytes, &policy)
+	if err != nil {
+		return RetentionPolicy{}, errors.New("Corrupt retention policy")
+	}
+	return policy, nil
+}
+
+//==============================================================================================================================
+//	 set_approval_policy - Sets the approval policy of a risk category. Admin only. A policy without required roles and
+//						   without quorum removes it, the approver alone decides again.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_approval_policy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		policy JSON object, e.g. {"RiskCategory":"HIGH","RequiredRoles":["broker","compliance_officer"]}
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can set approval policies")
+	}
+
+	var policy ApprovalPolicy
+	err = json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil {
+		return nil, errors.New("Invalid approval policy JSON")
+	}
+	if policy.RiskCategory != riskLow && policy.RiskCategory != riskMedium && policy.RiskCategory != riskHigh {
+		return nil, errors.New("RiskCategory must be LOW, MEDIUM or HIGH")
+	}
+	if policy.Quorum < 0 || policy.Quorum > len(policy.Reviewers) {
+		return nil, errors.New("Quorum must be between 0 and the number of reviewers")
+	}
+
+	if len(policy.RequiredRoles) == 0 && policy.Quorum == 0 {
+		err = stub.DelState(approvalPolicyPrefix + policy.RiskCategory)
+	} else {
+		policyAsBytes, _ := json.Marshal(policy)
+		err = stub.PutState(approvalPolicyPrefix + policy.RiskCategory, policyAsBytes)
+	}
+	if err != nil {
+		return nil, errors.New("Error putting approval policy on ledger")
+	}
+
+	return nil, t.write_audit(stub, "set_approval_policy", "approval", policy)
+}
+
+/*
+	Approval policy of the risk category the request was assessed in, nil when there is none. Enhanced due diligence
+	adds a compliance officer to the required roles, with or without a policy for the category.
+*/
+func (t *SimpleChaincode) get_request_approval_policy(stub *shim.ChaincodeStub, requestId string) (*ApprovalPolicy, error) {
+
+	assessment, err := t.get_risk_assessment(stub, requestId)
+	if err != nil || assessment == nil {
+		return nil, err
+	}
+
+	bytes, err := stub.GetState(approvalPolicyPrefix + assessment.Category)
+	if err != nil {
+		return nil, errors.New("Failed to get approval policy " + assessment.Category)
+	}
+
+	var policy *ApprovalPolicy
+	if bytes != nil {
+		policy = &ApprovalPolicy{}
+		err = json.Unmarshal(bytes, policy)
+		if err != nil {
+			return nil, errors.New("Corrupt approval policy " + assessment.Category)
+		}
+	}
+
+	if assessment.EDDRequired {
+		if policy == nil {
+			policy = &ApprovalPolicy{RiskCategory: assessment.Category}
+		}
+		if !contains_string(policy.RequiredRoles, roleComplianceOfficer) {
+			policy.RequiredRoles = append(policy.RequiredRoles, roleComplianceOfficer)
+		}
+	}
+	return policy, nil
+}
+
+//==============================================================================================================================
+//	 record_approval_decision - Records the decision of one reviewer on a request under an approval policy. The
+//								approver, the named reviewers and accessors holding a required role may decide, once
+//								each, and apart from the approver only from the request's organisation. Every
+//								approval counts for one required role. A rejection rejects the request, the approval
+//								completing the policy approves it.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_approval_decision(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1					2
+	//		requestId		APPROVE or REJECT		comment
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	if args[1] != decisionApprove && args[1] != decisionReject {
+		return nil, errors.New("Decision must be APPROVE or REJECT")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if b.Closure != nil || b.Status == statusApproved || b.Status == statusRejected {
+		return nil, errors.New("Brokerage request " + b.RequestID + " is already decided")
+	}
+	scope, err := t.caller_scope(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_request_readable(stub, &scope, b)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := t.get_request_approval_policy(stub, b.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, errors.New("No approval policy applies to " + b.RequestID + ", its approver decides alone")
+	}
+
+	/**** Who may decide ****/
+	if username == b.Submitter {
+		return nil, errors.New("Permission denied. The submitter cannot review their own request")
+	}
+	/**** Reviewers other than the approver come from the request's organisation ****/
+	var roles []string
+	organisation := ""
+	if a, err := t.get_accessor_struct(stub, username); err == nil {
+		roles = a.Roles
+		organisation = a.Organisation
+	}
+	if username != b.Approver && b.Organisation != "" && organisation != b.Organisation {
+		return nil, errors.New("Permission denied. " + username + " is not in organisation " + b.Organisation + " of " + b.RequestID)
+	}
+	eligible := username == b.Approver
+	for _, r := range policy.Reviewers {
+		eligible = eligible || r == username
+	}
+	for _, r := range policy.RequiredRoles {
+		for _, held := range roles {
+			eligible = eligible || r == held
+		}
+	}
+	if !eligible {
+		return nil, errors.New("Permission denied. " + username + " is not a reviewer of " + b.RequestID)
+	}
+
+	key := approvalDecisionPrefix + b.RequestID + "|" + username
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get " + key)
+	}
+	if existing != nil {
+		return nil, errors.New(username + " has already decided on " + b.RequestID)
+	}
+
+	decision := ApprovalDecision{
+		RequestID: b.RequestID,
+		Reviewer:  username,
+		Roles:     roles,
+		Decision:  args[1],
+		Comment:   args[2],
+		DecidedAt: now.Format(time.RFC3339),
+	}
+	decisionAsBytes, _ := json.Marshal(decision)
+	err = stub.PutState(key, decisionAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting approval decision on ledger")
+	}
+
+	err = t.write_audit(stub, "record_approval_decision", b.RequestID, decision)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Move the request once the outcome is known ****/
+	status, err := t.approval_status(stub, b)
+	if err != nil {
+		return nil, err
+	}
+	newStatus := approval_outcome(decision.Decision, status)
+	if newStatus == "" {
+		return nil, nil
+	}
+
+	/**** A still missing screening or UBO verification leaves it to the approver to approve later ****/
+	if t.check_status_guards(stub, b, newStatus) != nil {
+		return nil, nil
+	}
+	b.Status = newStatus
+	err = t.replace_brokerage_request(stub, b)
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.complete_status_change(stub, b)
+}
+
+/*
+	Status a recorded decision moves the request to: a rejection rejects it, the approval completing the policy
+	approves it, anything else leaves it where it is.
+*/
+func approval_outcome(decision string, status ApprovalStatus) string {
+	if decision == decisionReject {
+		return statusRejected
+	}
+	if status.Satisfied {
+		return statusApproved
+	}
+	return ""
+}
+
+/*
+	Decisions recorded on a request and whether they satisfy its approval policy. Without a policy the approver's
+	own status change is all that is needed.
+*/
+func (t *SimpleChaincode) approval_status(stub *shim.ChaincodeStub, b BrokerageRequest) (ApprovalStatus, error) {
+
+	status := ApprovalStatus{RequestID: b.RequestID, Decisions: []ApprovalDecision{}, Missing: []string{}}
+
+	policy, err := t.get_request_approval_policy(stub, b.RequestID)
+	if err != nil {
+		return status, err
+	}
+	status.Policy = policy
+
+	keys, values, err := range_by_prefix(stub, approvalDecisionPrefix + b.RequestID + "|")
+	if err != nil {
+		return status, err
+	}
+	for i := range keys {
+		var d ApprovalDecision
+		err = json.Unmarshal(values[i], &d)
+		if err != nil {
+			return status, errors.New("Corrupt approval decision " + keys[i])
+		}
+		status.Decisions = append(status.Decisions, d)
+	}
+
+	if policy == nil {
+		status.Satisfied = true
+		return status, nil
+	}
+
+	var approving []ApprovalDecision
+	for _, d := range status.Decisions {
+		if d.Decision == decisionApprove {
+			approving = append(approving, d)
+		}
+	}
+	for _, required := range unmatched_roles(policy.RequiredRoles, approving) {
+		status.Missing = append(status.Missing, "role " + required)
+	}
+
+	approvals := 0
+	for _, d := range status.Decisions {
+		for _, r := range policy.Reviewers {
+			if d.Decision == decisionApprove && d.Reviewer == r {
+				approvals++
+			}
+		}
+	}
+	if approvals < policy.Quorum {
+		status.Missing = append(status.Missing, strconv.Itoa(policy.Quorum - approvals) + " of reviewers " + strings.Join(policy.Reviewers, ", "))
+	}
+
+	status.Satisfied = len(status.Missing) == 0
+	return status, nil
+}
+
+/*
+	Required roles left over when every approving reviewer stands for one role only, so a broker who is also a
+	compliance officer does not satisfy both on their own. Augmenting-path matching of roles to reviewers.
+*/
+func unmatched_roles(required []string, approvals []ApprovalDecision) []string {
+
+	roleOf := map[int]int{}	// approval -> required role it stands for
+	var assign func(role int, tried map[int]bool) bool
+	assign = func(role int, tried map[int]bool) bool {
+		for i, d := range approvals {
+			if tried[i] || !contains_string(d.Roles, required[role]) {
+				continue
+			}
+			tried[i] = true
+			other, taken := roleOf[i]
+			if !taken || assign(other, tried) {
+				roleOf[i] = role
+				return true
+			}
+		}
+		return false
+	}
+
+	missing := []string{}
+	for role := range required {
+		if !assign(role, map[int]bool{}) {
+			missing = append(missing, required[role])
+		}
+	}
+	return missing
+}
+
+//==============================================================================================================================
+//	 assign_brokerage_request - Assigns an open request to a broker, or reassigns / escalates it. Admins may assign to
+//								anyone, operations accessors only within their organisation and only requests that
+//								are unassigned or held by their organisation.
+//==============================================================================================================================
+func (t *SimpleChaincode) assign_brokerage_request(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1				2
+	//		requestId		reviewerId		reason
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+	b, err := t.get_open_request_for_assignment(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	reviewer, err := t.get_accessor_struct(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if !has_role(reviewer, roleBroker) {
+		return nil, errors.New(args[1] + " is not a broker")
+	}
+	if reviewer.AccessorId == b.Submitter {
+		return nil, errors.New("Cannot assign " + b.RequestID + " to its submitter")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		caller, err := t.caller_accessor_with_role(stub, roleOperations)
+		if err != nil {
+			return nil, errors.New("Permission denied. Only operations accessors and admins can assign requests")
+		}
+		if caller.Organisation == "" || reviewer.Organisation != caller.Organisation {
+			return nil, errors.New("Permission denied. " + args[1] + " is not in organisation " + caller.Organisation)
+		}
+		if b.Approver != "" {
+			current, err := t.get_accessor_struct(stub, b.Approver)
+			if err == nil && current.Organisation != caller.Organisation {
+				return nil, errors.New("Permission denied. " + b.RequestID + " is held by another organisation")
+			}
+		}
+	}
+
+	return nil, t.assign_reviewer(stub, b, reviewer, args[2])
+}
+
+//==============================================================================================================================
+//	 claim_brokerage_request - A broker takes an unassigned open request into their own queue
+//==============================================================================================================================
+func (t *SimpleChaincode) claim_brokerage_request(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		requestId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	caller, err := t.caller_accessor_with_role(stub, roleBroker)
+	if err != nil {
+		return nil, errors.New("Permission denied. Only brokers can claim requests")
+	}
+
+	b, err := t.get_open_request_for_assignment(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if b.Approver != "" {
+		return nil, errors.New("Brokerage request " + b.RequestID + " is already assigned to " + b.Approver)
+	}
+	if caller.AccessorId == b.Submitter {
+		return nil, errors.New("Cannot claim your own request")
+	}
+
+	return nil, t.assign_reviewer(stub, b, caller, "claimed")
+}
+
+func (t *SimpleChaincode) get_open_request_for_assignment(stub *shim.ChaincodeStub, requestId string) (BrokerageRequest, error) {
+
+	b, err := t.get_brokerage_request_struct(stub, requestId)
+	if err != nil {
+		return b, err
+	}
+	if b.Closure != nil || !is_open_status(b.Status) {
+		return b, errors.New("Brokerage request " + requestId + " is no longer open")
+	}
+	return b, nil
+}
+
+/*
+	Makes the reviewer the approver of the request and records who assigned it and why.
+*/
+func (t *SimpleChaincode) assign_reviewer(stub *shim.ChaincodeStub, b BrokerageRequest, reviewer KyckAccessor, reason string) error {
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+
+	assignment := Assignment{
+		RequestID:    b.RequestID,
+		Reviewer:     reviewer.AccessorId,
+		Organisation: reviewer.Organisation,
+		AssignedBy:   username,
+		AssignedAt:   now.Format(time.RFC3339),
+		Reason:       reason,
+	}
+	assignmentAsBytes, _ := json.Marshal(assignment)
+	err = stub.PutState(assignmentPrefix + b.RequestID, assignmentAsBytes)
+	if err != nil {
+		return errors.New("Error putting assignment of " + b.RequestID + " on ledger")
+	}
+
+	previous := b.Approver
+	err = stub.DelState(customer_key(t.request_org(stub, b), b.Submitter, b.RequestID))
+	if err != nil {
+		return errors.New("Error removing " + b.RequestID + " from the customers of its organisation")
+	}
+	b.Approver = reviewer.AccessorId
+	if b.Organisation != reviewer.Organisation {
+		err = t.move_payload(stub, b.RequestID, b.Organisation, reviewer.Organisation)
+		if err != nil {
+			return err
+		}
+	}
+	b.Organisation = reviewer.Organisation
+	err = t.replace_brokerage_request(stub, b)
+	if err != nil {
+		return err
+	}
+	err = t.index_customer(stub, b)
+	if err != nil {
+		return err
+	}
+
+	err = t.write_audit(stub, "assign_brokerage_request", b.RequestID, map[string]string{"From": previous, "To": reviewer.AccessorId, "Reason": reason})
+	if err != nil {
+		return err
+	}
+
+	return t.notify(stub, reviewer.AccessorId, "request_assigned", map[string]string{"RequestID": b.RequestID, "Reason": reason})
+}
+
+//==============================================================================================================================
+//	 set_sla_policy - Hours an open request may wait before it is flagged. Admin only.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_sla_policy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		SLA policy JSON object, e.g. {"MaxWaitHours":72}
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can set the SLA policy")
+	}
+
+	var policy SLAPolicy
+	err = json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil || policy.MaxWaitHours <= 0 {
+		return nil, errors.New("Invalid SLA policy JSON")
+	}
+
+	policyAsBytes, _ := json.Marshal(policy)
+	err = stub.PutState(slaPolicyStr, policyAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting SLA policy on ledger")
+	}
+
+	return nil, t.write_audit(stub, "set_sla_policy", "sla", policy)
+}
+
+func (t *SimpleChaincode) get_sla_policy(stub *shim.ChaincodeStub) (SLAPolicy, error) {
+
+	bytes, err := stub.GetState(slaPolicyStr)
+	if err != nil {
+		return SLAPolicy{}, errors.New("Failed to get " + slaPolicyStr)
+	}
+	if bytes == nil {
+		return defaultSLAPolicy, nil
+	}
+
+	var policy SLAPolicy
+	err = json.Unmarshal(bytes, &policy)
+	if err != nil {
+		return SLAPolicy{}, errors.New("Corrupt SLA policy")
+	}
+	return policy, nil
+}
+
+/*
+	Open requests accepted by include, longest waiting first, with their age and SLA state.
+*/
+func (t *SimpleChaincode) open_queue_entries(stub *shim.ChaincodeStub, include func(b BrokerageRequest) bool) ([]QueueEntry, error) {
+
+	policy, err := t.get_sla_policy(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := get_index_ids(stub, applicationIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []QueueEntry{}
+	for _, id := range ids {
+		b, err := t.get_brokerage_request_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if b.Closure != nil || !is_open_status(b.Status) {
+			continue
+		}
+		if !include(b) {
+			continue
+		}
+
+		var stamps BrokerageRequestTimeStamp
+		json.Unmarshal([]byte(b.TimeStamps), &stamps)
+		submitted, err := time.Parse(time.UnixDate, stamps.Submit)
+		if err != nil {
+			submitted = now
+		}
+
+		infoRequests, err := t.get_info_requests(stub, b.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		age := review_age(submitted, now, infoRequests)
+		hours := int(age.Hours())
+		entries = append(entries, QueueEntry{
+			RequestID:   b.RequestID,
+			Submitter:   b.Submitter,
+			Reviewer:    b.Approver,
+			Status:      b.Status,
+			SubmittedAt: submitted.UTC().Format(time.RFC3339),
+			AgeHours:    hours,
+			SLABreached: hours > policy.MaxWaitHours && b.Status != statusInfoRequested,
+			submitted:   submitted,
+			age:         age,
+		})
+	}
+
+	sort.Sort(byAge(entries))
+	return entries, nil
+}
+
+/*
+	Time the request has waited for its reviewer since it was submitted. The clock stops while the customer is asked
+	for information, from RequestedAt until RespondedAt, or until now for the open one.
+*/
+func review_age(submitted time.Time, now time.Time, infoRequests []InfoRequest) time.Duration {
+
+	age := now.Sub(submitted)
+	for _, r := range infoRequests {
+		requested, err := time.Parse(time.RFC3339, r.RequestedAt)
+		if err != nil {
+			continue
+		}
+		responded := now
+		if r.Response != nil {
+			if at, err := time.Parse(time.RFC3339, r.Response.RespondedAt); err == nil {
+				responded = at
+			}
+		}
+		if responded.After(requested) {
+			age -= responded.Sub(requested)
+		}
+	}
+	if age < 0 {
+		age = 0
+	}
+	return age
+}
+
+//==============================================================================================================================
+//	 request_information - The approver asks the customer for more information on an open request, which waits in
+//						   INFO_REQUESTED until the customer responds. One request for information is open at a time.
+//==============================================================================================================================
+func (t *SimpleChaincode) request_information(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		requestId		info request JSON object, e.g. {"Items":[{"DocumentId":"d1","Message":"Unreadable"}],
+	//						"Message":"...","DueDate":"2017-03-01"}
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if b.Approver != username {
+		return nil, errors.New("Permission denied. Only the approver of " + b.RequestID + " can request information")
+	}
+	if b.Closure != nil || !is_open_status(b.Status) {
+		return nil, errors.New("Brokerage request " + b.RequestID + " is no longer open")
+	}
+	if b.Status == statusInfoRequested {
+		return nil, errors.New("Brokerage request " + b.RequestID + " is already waiting for information")
+	}
+
+	var r InfoRequest
+	err = json.Unmarshal([]byte(args[1]), &r)
+	if err != nil {
+		return nil, errors.New("Invalid info request JSON")
+	}
+	if len(r.Items) == 0 {
+		return nil, errors.New("At least one item is required")
+	}
+	for _, item := range r.Items {
+		if (item.DocumentId == "" && item.Field == "") || item.Message == "" {
+			return nil, errors.New("Every item needs a DocumentId or Field and a Message")
+		}
+	}
+	due, err := time.Parse(dateLayout, r.DueDate)
+	if err != nil {
+		return nil, errors.New("DueDate must be a date in YYYY-MM-DD format")
+	}
+	if due.Before(now.Truncate(24 * time.Hour)) {
+		return nil, errors.New("DueDate cannot be in the past")
+	}
+
+	existing, err := t.get_info_requests(stub, b.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	r.RequestID = b.RequestID
+	r.Sequence = len(existing) + 1
+	r.RequestedBy = username
+	r.RequestedAt = now.Format(time.RFC3339)
+	r.Response = nil
+
+	err = t.put_info_request(stub, r)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Status = statusInfoRequested
+	err = t.replace_brokerage_request(stub, b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.write_audit(stub, "request_information", b.RequestID, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, t.notify(stub, b.Submitter, "information_requested", map[string]string{"RequestID": b.RequestID, "DueDate": r.DueDate})
+}
+
+//==============================================================================================================================
+//	 respond_to_information_request - The submitter answers the open request for information, optionally with new
+//									  documents. The brokerage request goes back to IN_REVIEW.
+//==============================================================================================================================
+func (t *SimpleChaincode) respond_to_information_request(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		requestId		response JSON object, e.g. {"Message":"...","Documents":[{"Hash":"...","Path":"..."}]}
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if b.Submitter != username {
+		return nil, errors.New("Permission denied. Only the submitter of " + b.RequestID + " can respond")
+	}
+	if b.Closure != nil || b.Status != statusInfoRequested {
+		return nil, errors.New("Brokerage request " + b.RequestID + " is not waiting for information")
+	}
+
+	var response InfoResponse
+	err = json.Unmarshal([]byte(args[1]), &response)
+	if err != nil {
+		return nil, errors.New("Invalid response JSON")
+	}
+	if response.Message == "" && len(response.Documents) == 0 {
+		return nil, errors.New("A message or at least one document is required")
+	}
+	for _, d := range response.Documents {
+		if d.Hash == "" || d.Path == "" {
+			return nil, errors.New("Every document needs a Hash and a Path")
+		}
+	}
+
+	requests, err := t.get_info_requests(stub, b.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 || requests[len(requests) - 1].Response != nil {
+		return nil, errors.New("No open request for information on " + b.RequestID)
+	}
+	r := requests[len(requests) - 1]
+
+	response.RespondedBy = username
+	response.RespondedAt = now.Format(time.RFC3339)
+	response.Late = now.Format(dateLayout) > r.DueDate
+	r.Response = &response
+
+	/**** Only under the resources prefix, a bare username+hash key could land on any other record. get_resource
+		  finds them there as well. ****/
+	for _, d := range response.Documents {
+		err = stub.PutState(resourcePrefix + username + "|" + d.Hash, []byte(d.Path))
+		if err != nil {
+			return nil, errors.New("Error putting resource index on ledger")
+		}
+	}
+
+	err = t.put_info_request(stub, r)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Status = statusInReview
+	err = t.replace_brokerage_request(stub, b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.write_audit(stub, "respond_to_information_request", b.RequestID, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, t.notify(stub, b.Approver, "information_received", map[string]string{"RequestID": b.RequestID})
+}
+
+func (t *SimpleChaincode) put_info_request(stub *shim.ChaincodeStub, r InfoRequest) error {
+
+	bytes, _ := json.Marshal(r)
+	err := stub.PutState(infoRequestPrefix + r.RequestID + "|" + fmt.Sprintf("%04d", r.Sequence), bytes)
+	if err != nil {
+		return errors.New("Error putting info request on ledger")
+	}
+	return nil
+}
+
+/*
+	Every request for information on a brokerage request, oldest first.
+*/
+func (t *SimpleChaincode) get_info_requests(stub *shim.ChaincodeStub, requestId string) ([]InfoRequest, error) {
+
+	keys, values, err := range_by_prefix(stub, infoRequestPrefix + requestId + "|")
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []InfoRequest{}
+	for i := range keys {
+		var r InfoRequest
+		err = json.Unmarshal(values[i], &r)
+		if err != nil {
+			return nil, errors.New("Corrupt info request " + keys[i])
+		}
+		requests = append(requests, r)
+	}
+	return requests, nil
+}
+
+//==============================================================================================================================
+//	 notify - Writes a notification intent for a customer or accessor to the outbox. Accessors are reached on their
+//			  first email address, customers on their email address, both fall back to their phone. Recipients
+//			  without contact details are skipped.
+//==============================================================================================================================
+func (t *SimpleChaincode) notify(stub *shim.ChaincodeStub, recipient string, templateId string, params map[string]string) error {
+
+	if recipient == "" {
+		return nil
+	}
+
+	/**** Only the channel goes on the ledger, the worker gets the address from get_pending_notifications ****/
+	n := Notification{Recipient: recipient, TemplateId: templateId, Parameters: params}
+	n.Channel, _ = t.recipient_contact(stub, recipient)
+	if n.Channel == "" {
+		return nil
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	n.CreatedAt = now.Format(time.RFC3339)
+
+	/**** Sortable by creation, unique per transaction, recipient and template. Another call with the same three in
+		  this transaction, e.g. when the submitter is also the approver, gets a sequence suffix. ****/
+	base := fmt.Sprintf("%020d", now.UnixNano()) + "_" + stub.GetTxID() + "_" + recipient + "_" + templateId
+	n.NotificationId = base
+	for seq := 2; ; seq++ {
+		existing, err := stub.GetState(outboxPrefix + n.NotificationId)
+		if err != nil {
+			return errors.New("Failed to get notification " + n.NotificationId)
+		}
+		if existing == nil {
+			break
+		}
+		n.NotificationId = base + "_" + strconv.Itoa(seq)
+	}
+
+	bytes, _ := json.Marshal(n)
+	err = stub.PutState(outboxPrefix + n.NotificationId, bytes)
+	if err != nil {
+		return errors.New("Error putting notification on ledger")
+	}
+	return nil
+}
+
+/**** Channel and current address to reach an accessor or user on, email first. Empty when there is none. ****/
+func (t *SimpleChaincode) recipient_contact(stub *shim.ChaincodeStub, recipient string) (string, string) {
+
+	var email, phone string
+	if a, err := t.get_accessor_struct(stub, recipient); err == nil {
+		if len(a.Email) > 0 {
+			email = a.Email[0]
+		}
+		phone = a.Phone
+	} else if u, err := t.get_user_struct(stub, recipient); err == nil {
+		email = u.EmailAddress
+		phone = u.PhoneNumber
+	}
+
+	if email != "" {
+		return channelEmail, email
+	}
+	if phone != "" {
+		return channelSMS, phone
+	}
+	return "", ""
+}
+
+func (t *SimpleChaincode) notify_status_change(stub *shim.ChaincodeStub, b BrokerageRequest) error {
+
+	return t.notify(stub, b.Submitter, "request_status_changed", map[string]string{"RequestID": b.RequestID, "Status": b.Status})
+}
+
+/*
+	What follows a status change, whether the approver made it or an approval policy completed: the customer is
+	notified, and approving a corporate request verifies its entity.
+*/
+func (t *SimpleChaincode) complete_status_change(stub *shim.ChaincodeStub, b BrokerageRequest) error {
+
+	err := t.notify_status_change(stub, b)
+	if err != nil {
+		return err
+	}
+	if b.Status != statusApproved || b.EntityId == "" {
+		return nil
+	}
+
+	e, err := t.get_entity_struct(stub, b.EntityId)
+	if err != nil {
+		return err
+	}
+	verify_entity(&e, b)
+	return t.put_entity(stub, e)
+}
+
+func verify_entity(e *KyckEntity, b BrokerageRequest) {
+	e.Verified = true
+	e.VerifiedBy = b.Approver
+	e.SourceRequestID = b.RequestID
+}
+
+//==============================================================================================================================
+//	 acknowledge_notification - Marks a notification delivered and moves it out of the pending outbox. A
+//								notification is acknowledged once, a second acknowledgement fails. Notifiers only.
+//==============================================================================================================================
+func (t *SimpleChaincode) acknowledge_notification(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0					1
+	//		notificationId		delivery reference
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	caller, err := t.caller_accessor_with_role(stub, roleNotifier)
+	if err != nil {
+		return nil, errors.New("Permission denied. Only notifiers can acknowledge notifications")
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := stub.GetState(outboxPrefix + args[0])
+	if err != nil {
+		return nil, errors.New("Failed to get notification " + args[0])
+	}
+	if bytes == nil {
+		done, _ := stub.GetState(outboxDonePrefix + args[0])
+		if done != nil {
+			return nil, errors.New("Notification " + args[0] + " has already been acknowledged")
+		}
+		return nil, errors.New("Notification " + args[0] + " does not exist")
+	}
+
+	var n Notification
+	err = json.Unmarshal(bytes, &n)
+	if err != nil {
+		return nil, errors.New("Corrupt notification " + args[0])
+	}
+	n.Acknowledged = true
+	n.AcknowledgedBy = caller.AccessorId
+	n.AcknowledgedAt = now.Format(time.RFC3339)
+	n.DeliveryRef = args[1]
+
+	bytes, _ = json.Marshal(n)
+	err = stub.PutState(outboxDonePrefix + args[0], bytes)
+	if err != nil {
+		return nil, errors.New("Error putting notification on ledger")
+	}
+	err = stub.DelState(outboxPrefix + args[0])
+	if err != nil {
+		return nil, errors.New("Error removing notification from the outbox")
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 issue_contact_code - Records the hash of a one-time code the notifier has sent to a user's current email
+//						  address or phone number. Replaces any earlier code for that channel. The hash is an
+//						  HMAC under a random salt of the notifier's choosing, and confirm_contact only accepts
+//						  codes of at least minCodeLength characters, so the code cannot be recovered from state.
+//==============================================================================================================================
+func (t *SimpleChaincode) issue_contact_code(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1					2												3					4
+	//		userId		EMAIL or SMS		HMAC-SHA256 hex of userId|channel|code		salt (hex key)		expiry (RFC 3339)
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5")
+	}
+
+	caller, err := t.caller_accessor_with_role(stub, roleNotifier)
+	if err != nil {
+		return nil, errors.New("Permission denied. Only notifiers can issue contact codes")
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := t.get_user_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	address, err := contact_address(u, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args[2]) != 64 {
+		return nil, errors.New("Code hash must be an HMAC-SHA256 hex digest")
+	}
+	if _, err := hex.DecodeString(args[3]); err != nil || len(args[3]) < minSaltLength {
+		return nil, errors.New("Salt must be at least " + strconv.Itoa(minSaltLength) + " hex characters")
+	}
+	expires, err := time.Parse(time.RFC3339, args[4])
+	if err != nil {
+		return nil, errors.New("Expiry must be an RFC 3339 timestamp")
+	}
+	if !expires.After(now) || expires.Sub(now) > maxCodeLifetime {
+		return nil, errors.New("Expiry must be in the future and at most 24 hours away")
+	}
+
+	v := ContactVerification{
+		UserId:    u.UserId,
+		Channel:   args[1],
+		Address:   address,
+		CodeHash:  strings.ToLower(args[2]),
+		CodeSalt:  strings.ToLower(args[3]),
+		ExpiresAt: expires.UTC().Format(time.RFC3339),
+		IssuedBy:  caller.AccessorId,
+		IssuedAt:  now.Format(time.RFC3339),
+	}
+	err = t.put_contact_verification(stub, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, t.write_audit(stub, "issue_contact_code", u.UserId, map[string]string{"Channel": v.Channel, "ExpiresAt": v.ExpiresAt})
+}
+
+//==============================================================================================================================
+//	 confirm_contact - The user confirms a channel with the code they received. A wrong code still commits, so the
+//					   attempt counts; the outcome is in LastResult, see get_contact_verification. After
+//					   maxCodeAttempts wrong codes a new code has to be issued. Codes shorter than
+//					   minCodeLength are refused outright and do not count as an attempt.
+//==============================================================================================================================
+func (t *SimpleChaincode) confirm_contact(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		EMAIL or SMS		code
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args[1]) < minCodeLength {
+		return nil, errors.New("Code must be at least " + strconv.Itoa(minCodeLength) + " characters")
+	}
+
+	v, err := t.get_contact_verification_struct(stub, username, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if v.CodeHash == "" {
+		return nil, errors.New("No code has been issued for " + args[0])
+	}
+
+	expires, _ := time.Parse(time.RFC3339, v.ExpiresAt)
+	if v.Attempts >= maxCodeAttempts {
+		v.LastResult = codeLocked
+	} else if !now.Before(expires) {
+		v.LastResult = codeExpired
+	} else {
+		v.Attempts++
+		if hmac.Equal([]byte(code_hash(v.CodeSalt, username, args[0], args[1])), []byte(v.CodeHash)) {
+			v.LastResult = codeConfirmed
+			v.Verified = true
+			v.VerifiedAt = now.Format(time.RFC3339)
+			v.CodeHash = ""		//Single use
+			v.CodeSalt = ""
+		} else {
+			v.LastResult = codeInvalid
+		}
+	}
+
+	err = t.put_contact_verification(stub, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.LastResult == codeConfirmed {
+		err = t.write_audit(stub, "confirm_contact", username, map[string]string{"Channel": v.Channel, "Address": v.Address})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []byte(v.LastResult), nil
+}
+
+func contact_address(u User, channel string) (string, error) {
+
+	address := ""
+	if channel == channelEmail {
+		address = u.EmailAddress
+	} else if channel == channelSMS {
+		address = u.PhoneNumber
+	} else {
+		return "", errors.New("Channel must be EMAIL or SMS")
+	}
+	if address == "" {
+		return "", errors.New(u.UserId + " has no contact details for " + channel)
+	}
+	return address, nil
+}
+
+func (t *SimpleChaincode) get_contact_verification_struct(stub *shim.ChaincodeStub, userId string, channel string) (ContactVerification, error) {
+
+	v := ContactVerification{UserId: userId, Channel: channel}
+	if channel != channelEmail && channel != channelSMS {
+		return v, errors.New("Channel must be EMAIL or SMS")
+	}
+
+	bytes, err := stub.GetState(contactVerificationPrefix + userId + "|" + channel)
+	if err != nil {
+		return v, errors.New("Failed to get contact verification of " + userId)
+	}
+	if bytes == nil {
+		return v, nil
+	}
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil {
+		return v, errors.New("Corrupt contact verification of " + userId)
+	}
+	return v, nil
+}
+
+func (t *SimpleChaincode) put_contact_verification(stub *shim.ChaincodeStub, v ContactVerification) error {
+
+	bytes, _ := json.Marshal(v)
+	err := stub.PutState(contactVerificationPrefix + v.UserId + "|" + v.Channel, bytes)
+	if err != nil {
+		return errors.New("Error putting contact verification on ledger")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 register_organisation - Registers a broker, agency or regulator organisation. Admin only.
+//==============================================================================================================================
+func (t *SimpleChaincode) register_organisation(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		organisation JSON object, e.g. {"OrgId":"acme","Name":"Acme Brokers","Type":"BROKER"}
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can register organisations")
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var org Organisation
+	err = json.Unmarshal([]byte(args[0]), &org)
+	if err != nil {
+		return nil, errors.New("Invalid organisation JSON")
+	}
+	if org.OrgId == "" || org.Name == "" {
+		return nil, errors.New("OrgId and Name are required")
+	}
+	if org.Type != "BROKER" && org.Type != "AGENCY" && org.Type != "REGULATOR" {
+		return nil, errors.New("Type must be BROKER, AGENCY or REGULATOR")
+	}
+
+	existing, err := stub.GetState(orgPrefix + org.OrgId)
+	if err != nil {
+		return nil, errors.New("Failed to get organisation " + org.OrgId)
+	}
+	if existing != nil {
+		return nil, errors.New("Organisation " + org.OrgId + " already exists")
+	}
+
+	org.CreatedAt = now.Format(time.RFC3339)
+	orgAsBytes, _ := json.Marshal(org)
+	err = stub.PutState(orgPrefix + org.OrgId, orgAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting organisation on ledger")
+	}
+
+	return nil, t.write_audit(stub, "register_organisation", org.OrgId, org)
+}
+
+func (t *SimpleChaincode) get_organisation(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		orgId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	orgAsBytes, err := stub.GetState(orgPrefix + args[0])
+	if err != nil {
+		return nil, errors.New("Failed to get organisation " + args[0])
+	}
+	if orgAsBytes == nil {
+		return nil, errors.New("Organisation " + args[0] + " does not exist")
+	}
+	return orgAsBytes, nil
+}
+
+/*
+	Admins and cross-org regulators see everything, accessors the records of their organisation, everybody their
+	own records.
+*/
+func (t *SimpleChaincode) caller_scope(stub *shim.ChaincodeStub) (CallerScope, error) {
+
+	scope := CallerScope{approverOrgs: map[string]string{}}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return scope, err
+	}
+	scope.UserId = username
+
+	role, _ := t.get_role(stub)
+	if role == roleAdmin {
+		scope.All = true
+		return scope, nil
+	}
+
+	if a, err := t.get_accessor_struct(stub, username); err == nil {
+		scope.All = has_role(a, roleCrossOrgRegulator)
+		scope.Org = a.Organisation
+	}
+	return scope, nil
+}
+
+/**** Customers with requests handled by the caller's organisation, none outside an organisation ****/
+func org_customers(stub *shim.ChaincodeStub, scope CallerScope) (map[string]bool, error) {
+
+	customers := map[string]bool{}
+	if scope.All || scope.Org == "" {
+		return customers, nil
+	}
+	_, values, err := range_by_prefix(stub, customerPrefix + scope.Org + "|")
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		customers[string(v)] = true
+	}
+	return customers, nil
+}
+
+/*
+	Requests written before organisations existed have none recorded, they count for their approver's organisation.
+*/
+func (t *SimpleChaincode) request_visible(stub *shim.ChaincodeStub, scope *CallerScope, b BrokerageRequest) bool {
+
+	if scope.All || b.Submitter == scope.UserId || b.Approver == scope.UserId {
+		return true
+	}
+	if scope.Org == "" {
+		return false
+	}
+
+	org := b.Organisation
+	if org == "" && b.Approver != "" {
+		if _, ok := scope.approverOrgs[b.Approver]; !ok {
+			scope.approverOrgs[b.Approver] = t.request_org(stub, b)
+		}
+		org = scope.approverOrgs[b.Approver]
+	}
+	return org == scope.Org
+}
+
+/*
+	Organisation a request counts for, its approver's for requests written before organisations existed.
+*/
+func (t *SimpleChaincode) request_org(stub *shim.ChaincodeStub, b BrokerageRequest) string {
+
+	if b.Organisation != "" || b.Approver == "" {
+		return b.Organisation
+	}
+	a, _ := t.get_accessor_struct(stub, b.Approver)
+	return a.Organisation
+}
+
+func customer_key(org string, userId string, requestId string) string {
+	return customerPrefix + org + "|" + userId + "|" + requestId
+}
+
+func consent_key(userId string, accessorId string) string {
+	return consentPrefix + userId + "|" + accessorId
+}
+
+/*
+	Lists the submitter of a request as a customer of the request's organisation. Written on insert and assignment,
+	rebuilt by migrate_indexes.
+*/
+func (t *SimpleChaincode) index_customer(stub *shim.ChaincodeStub, b BrokerageRequest) error {
+
+	org := t.request_org(stub, b)
+	if org == "" {
+		return nil
+	}
+	err := stub.PutState(customer_key(org, b.Submitter, b.RequestID), []byte(b.Submitter))
+	if err != nil {
+		return errors.New("Error adding " + b.RequestID + " to the customers of " + org)
+	}
+	return nil
+}
+
+/*
+	Readers of a single request: it has to be visible to the caller and, once closed, the caller has to be one of its
+	parties or a regulator.
+*/
+func (t *SimpleChaincode) check_request_readable(stub *shim.ChaincodeStub, scope *CallerScope, b BrokerageRequest) error {
+
+	if !t.request_visible(stub, scope, b) {
+		return errors.New("Permission denied. Brokerage request " + b.RequestID + " belongs to another organisation")
+	}
+	if b.Closure != nil && scope.UserId != b.Submitter && scope.UserId != b.Approver {
+		isRegulator, err := t.caller_is_regulator(stub)
+		if err != nil {
+			return err
+		}
+		if !isRegulator {
+			return errors.New("Brokerage request " + b.RequestID + " is closed")
+		}
+	}
+	return nil
+}
+
+/*
+	Moves the PII of a new brokerage request to the restricted payload of its organisation and leaves the hashes in
+	the shared row. Empty fields stay empty. Returns the salt of the hashes.
+*/
+func (t *SimpleChaincode) seal_payload(stub *shim.ChaincodeStub, b *BrokerageRequest) (string, error) {
+
+	payload := RestrictedPayload{
+		RequestID:       b.RequestID,
+		Organisation:    b.Organisation,
+		Documents:       b.Documents,
+		PersonalDetails: b.PersonalDetails,
+		KYCDetails:      b.KYCDetails,
+	}
+	plainAsBytes, _ := json.Marshal(payload)
+	payload.Salt = new_salt(stub, plainAsBytes)
+	payloadAsBytes, _ := json.Marshal(payload)
+	err := stub.PutState(privatePrefix + b.Organisation + "|" + b.RequestID, payloadAsBytes)
+	if err != nil {
+		return "", errors.New("Error putting restricted payload of " + b.RequestID + " on ledger")
+	}
+
+	b.Documents = sealed_value(payload.Salt, b.Documents)
+	b.PersonalDetails = sealed_value(payload.Salt, b.PersonalDetails)
+	b.KYCDetails = sealed_value(payload.Salt, b.KYCDetails)
+	return payload.Salt, nil
+}
+
+/*
+	HMAC-SHA256 of a value keyed with the salt of its restricted record, so a hash handed out by a read function, in
+	the history or in the audit log can't be confirmed by guessing the value. The salt sits in the world state next to
+	the value, it hides nothing from whoever reads that. Payloads sealed before the salt keep their plain SHA-256.
+*/
+func sealed_value(salt string, value string) string {
+	if value == "" || is_sealed(value) {
+		return value
+	}
+	if salt == "" {
+		return legacySealedPrefix + sha256_hex([]byte(value))
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(value))
+	return sealedPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func is_sealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix) || strings.HasPrefix(value, legacySealedPrefix)
+}
+
+/**** Plain PII or an unsalted hash, both left to seal_legacy_payloads ****/
+func legacy_sealed(value string) bool {
+	return value != "" && !strings.HasPrefix(value, sealedPrefix)
+}
+
+/*
+	Unsalted hashes in old history snapshots are keyed again with the salt. They no longer match anything, but can't
+	be confirmed by guessing either.
+*/
+func resealed_value(salt string, value string) string {
+	if strings.HasPrefix(value, legacySealedPrefix) {
+		return sealed_value(salt, strings.TrimPrefix(value, legacySealedPrefix))
+	}
+	return sealed_value(salt, value)
+}
+
+/*
+	Salt of a restricted record. Chaincode has no randomness every endorser agrees on, so it is derived from the
+	transaction and the whole plain record: reproducing it from a hash alone takes the very values it protects.
+*/
+func new_salt(stub *shim.ChaincodeStub, plain []byte) string {
+	return sha256_hex([]byte(stub.GetTxID() + "|" + string(plain)))
+}
+
+/*
+	Stores the plain values of a KYC package, user or profile change under its restricted key and returns the hash of
+	each field for the shared record, and their salt.
+*/
+func seal_fields(stub *shim.ChaincodeStub, key string, values map[string]string) (map[string]string, string, error) {
+
+	plainAsBytes, _ := json.Marshal(values)
+	record := RestrictedRecord{Salt: new_salt(stub, []byte(key + "|" + string(plainAsBytes))), Values: values}
+	recordAsBytes, _ := json.Marshal(record)
+	err := stub.PutState(key, recordAsBytes)
+	if err != nil {
+		return nil, "", errors.New("Error putting " + key + " on ledger")
+	}
+
+	hashes := map[string]string{}
+	for field, value := range values {
+		hashes[field] = sealed_value(record.Salt, value)
+	}
+	return hashes, record.Salt, nil
+}
+
+/*
+	Plain values of a restricted record, checked against the hashes in the shared record. Records written before
+	sealing have no restricted record: found is false and the shared values are still plain.
+*/
+func open_fields(stub *shim.ChaincodeStub, key string, hashes map[string]string) (map[string]string, bool, error) {
+
+	bytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, false, errors.New("Failed to get " + key)
+	}
+	if bytes == nil {
+		return nil, false, nil
+	}
+
+	var record RestrictedRecord
+	err = json.Unmarshal(bytes, &record)
+	if err != nil {
+		return nil, false, errors.New("Corrupt " + key)
+	}
+	for field, hash := range hashes {
+		if sealed_value(record.Salt, record.Values[field]) != hash {
+			return nil, false, errors.New(key + " does not match its hashes")
+		}
+	}
+	return record.Values, true, nil
+}
+
+/*
+	Fills the PII of a brokerage request back in from its restricted payload, checked against the shared hashes.
+	Requests from before the separation, and purged ones, have no payload and are left as they are.
+*/
+func (t *SimpleChaincode) open_payload(stub *shim.ChaincodeStub, b *BrokerageRequest) error {
+
+	bytes, err := stub.GetState(privatePrefix + b.Organisation + "|" + b.RequestID)
+	if err != nil {
+		return errors.New("Failed to get restricted payload of " + b.RequestID)
+	}
+	if bytes == nil {
+		return nil
+	}
+
+	var payload RestrictedPayload
+	err = json.Unmarshal(bytes, &payload)
+	if err != nil {
+		return errors.New("Corrupt restricted payload of " + b.RequestID)
+	}
+	if sealed_value(payload.Salt, payload.Documents) != b.Documents ||
+		sealed_value(payload.Salt, payload.PersonalDetails) != b.PersonalDetails ||
+		sealed_value(payload.Salt, payload.KYCDetails) != b.KYCDetails {
+		return errors.New("Restricted payload of " + b.RequestID + " does not match its hashes")
+	}
+
+	b.Documents = payload.Documents
+	b.PersonalDetails = payload.PersonalDetails
+	b.KYCDetails = payload.KYCDetails
+	return nil
+}
+
+func (t *SimpleChaincode) move_payload(stub *shim.ChaincodeStub, requestId string, fromOrg string, toOrg string) error {
+
+	bytes, err := stub.GetState(privatePrefix + fromOrg + "|" + requestId)
+	if err != nil {
+		return errors.New("Failed to get restricted payload of " + requestId)
+	}
+	if bytes == nil {
+		return nil
+	}
+
+	var payload RestrictedPayload
+	json.Unmarshal(bytes, &payload)
+	payload.Organisation = toOrg
+	payloadAsBytes, _ := json.Marshal(payload)
+
+	err = stub.PutState(privatePrefix + toOrg + "|" + requestId, payloadAsBytes)
+	if err != nil {
+		return errors.New("Error putting restricted payload of " + requestId + " on ledger")
+	}
+	err = stub.DelState(privatePrefix + fromOrg + "|" + requestId)
+	if err != nil {
+		return errors.New("Error removing restricted payload of " + requestId)
+	}
+	return nil
+}
+
+/*
+	The parties of a request and the members of its organisation read its PII through the chaincode, everybody else,
+	admins and cross-org regulators included, only the hashes.
+*/
+func (t *SimpleChaincode) payload_member(scope *CallerScope, b BrokerageRequest) bool {
+
+	if b.Submitter == scope.UserId || b.Approver == scope.UserId {
+		return true
+	}
+	return scope.Org != "" && scope.Org == b.Organisation
+}
+
+//==============================================================================================================================
+//	 seal_legacy_payloads - Moves the PII of requests written before the restricted payloads into them, and replaces it
+//							with the hashes in their history as well. Payloads hashed before the salt are sealed
+//							again with one. Then does the same for users' personal details, KYC packages and
+//							profile changes. Admin only, at most max records per call.
+//==============================================================================================================================
+func (t *SimpleChaincode) seal_legacy_payloads(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		maximum number of records to seal in this transaction
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	max, err := strconv.Atoi(args[0])
+	if err != nil || max <= 0 {
+		return nil, errors.New("Maximum must be a positive integer")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can seal payloads")
+	}
+
+	ids, err := get_index_ids(stub, applicationIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := []string{}
+	for _, id := range ids {
+		if len(sealed) == max {
+			break
+		}
+
+		b, err := t.get_brokerage_request_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if !legacy_sealed(b.Documents) && !legacy_sealed(b.PersonalDetails) && !legacy_sealed(b.KYCDetails) {
+			continue
+		}
+
+		/**** Unsalted hashes are opened again, without a payload to open they have to stay ****/
+		err = t.open_payload(stub, &b)
+		if err != nil {
+			return nil, err
+		}
+		if is_sealed(b.Documents) || is_sealed(b.PersonalDetails) || is_sealed(b.KYCDetails) {
+			continue
+		}
+
+		/**** Requests approved before organisations get their approver's ****/
+		if b.Organisation == "" {
+			if a, err := t.get_accessor_struct(stub, b.Approver); err == nil {
+				b.Organisation = a.Organisation
+			}
+		}
+
+		salt, err := t.seal_payload(stub, &b)
+		if err != nil {
+			return nil, err
+		}
+		err = t.rewrite_history(stub, id, func(snapshot *BrokerageRequest) {
+			snapshot.Documents = resealed_value(salt, snapshot.Documents)
+			snapshot.PersonalDetails = resealed_value(salt, snapshot.PersonalDetails)
+			snapshot.KYCDetails = resealed_value(salt, snapshot.KYCDetails)
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = t.replace_brokerage_request(stub, b)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, id)
+	}
+
+	/**** Users with their personal details still in the shared record, and in its history ****/
+	userIds, err := get_index_ids(stub, usersIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range userIds {
+		if len(sealed) == max {
+			break
+		}
+
+		var fields map[string]json.RawMessage
+		bytes, err := stub.GetState(id)
+		if err != nil {
+			return nil, errors.New("Failed to get " + id)
+		}
+		if json.Unmarshal(bytes, &fields) != nil || fields["personalDetails"] == nil || string(fields["personalDetails"]) == "null" {
+			continue
+		}
+
+		u, err := t.get_user_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		err = t.put_user_struct(stub, u)
+		if err != nil {
+			return nil, err
+		}
+		err = t.rewrite_user_history(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, "user:" + id)
+	}
+
+	/**** KYC packages and profile changes without a restricted record ****/
+	keys, values, err := range_by_prefix(stub, kyckUserPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if len(sealed) == max {
+			break
+		}
+
+		var k KyckUser
+		if json.Unmarshal(values[i], &k) != nil {
+			return nil, errors.New("Corrupt KYC package under " + keys[i])
+		}
+		private, err := stub.GetState(privateKYCPrefix + k.UserId)
+		if err != nil {
+			return nil, errors.New("Failed to get restricted KYC package of " + k.UserId)
+		}
+		if private != nil {
+			continue
+		}
+		err = t.put_kyck_user(stub, k)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, "kyc:" + k.UserId)
+	}
+
+	keys, values, err = range_by_prefix(stub, profileChangePrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if len(sealed) == max {
+			break
+		}
+
+		var c ProfileChange
+		if json.Unmarshal(values[i], &c) != nil {
+			return nil, errors.New("Corrupt profile change " + keys[i])
+		}
+		private, err := stub.GetState(privateChangePrefix + c.UserId + "|" + c.ChangeId)
+		if err != nil {
+			return nil, errors.New("Failed to get restricted profile change " + c.ChangeId)
+		}
+		if private != nil {
+			continue
+		}
+		_, err = t.put_profile_change(stub, c)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, "change:" + c.UserId + "|" + c.ChangeId)
+	}
+
+	err = t.write_audit(stub, "seal_legacy_payloads", "private", sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedAsBytes, _ := json.Marshal(sealed)
+	return sealedAsBytes, nil
+}
+
+//==============================================================================================================================
+//	 issue_attestation - A customer with a verified, unexpired KYC package asks for an attestation of one claim. The
+//						 claim is only issued when it holds for the package.
+//==============================================================================================================================
+func (t *SimpleChaincode) issue_attestation(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0										1
+	//		AGE_OVER, RESIDENT_IN or KYC_LEVEL		claim value, e.g. 18, EU or STANDARD
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := t.get_kyck_user_struct(stub, username)
+	if err != nil {
+		return nil, err
+	}
+	err = evaluate_claim(k, args[0], args[1], now)
+	if err != nil {
+		return nil, err
+	}
+
+	a := Attestation{
+		AttestationId:   stub.GetTxID(),
+		Subject:         username,
+		ClaimType:       args[0],
+		ClaimValue:      args[1],
+		SourceRequestID: k.SourceRequestID,
+		IssuedAt:        now.Format(time.RFC3339),
+		ExpiresAt:       k.KYCExpiryDate,
+	}
+	a.Digest = attestation_digest(a)
+
+	err = t.put_attestation(stub, a)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.write_audit(stub, "issue_attestation", username, a)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(a.AttestationId), nil
+}
+
+/*
+	A KYC package is expired from its expiry date on, both dates YYYY-MM-DD. No date means it does not expire.
+*/
+func kyc_expired(expiryDate string, today string) bool {
+	return expiryDate != "" && expiryDate <= today
+}
+
+/*
+	Checks a claim against a KYC package: it must be verified and unexpired, and the claimed attribute must hold. The
+	answer is only as good as the package, which store_kyc_package writes for approved requests with passed documents.
+*/
+func evaluate_claim(k KyckUser, claimType string, claimValue string, now time.Time) error {
+
+	if !k.Verified {
+		return errors.New("KYC package of " + k.UserId + " is not verified")
+	}
+	if kyc_expired(k.KYCExpiryDate, now.Format(dateLayout)) {
+		return errors.New("KYC package of " + k.UserId + " expired on " + k.KYCExpiryDate)
+	}
+
+	var p PersonalDetails
+	json.Unmarshal(k.PersonalDetails, &p)
+
+	switch claimType {
+	case claimAgeOver:
+		years, err := strconv.Atoi(claimValue)
+		if err != nil || years <= 0 {
+			return errors.New("AGE_OVER needs a positive number of years")
+		}
+		dob, err := time.Parse(dateLayout, p.DateOfBirth)
+		if err != nil {
+			return errors.New("KYC package of " + k.UserId + " has no date of birth")
+		}
+		if dob.AddDate(years, 0, 0).After(now) {
+			return errors.New("Claim does not hold")
+		}
+	case claimResidentIn:
+		if claimValue != "EU" && !countryCodes[claimValue] {
+			return errors.New("RESIDENT_IN needs EU or an ISO 3166-1 alpha-2 country code")
+		}
+		resident := false
+		for _, address := range p.Addresses {
+			if address.Type != addressResidential {
+				continue
+			}
+			resident = resident || address.Country == claimValue || (claimValue == "EU" && euCountries[address.Country])
+		}
+		if !resident {
+			return errors.New("Claim does not hold")
+		}
+	case claimKYCLevel:
+		/**** Not the approver's assessment alone, a compliance officer has to have approved the EDD ****/
+		level := kycLevelStandard
+		if k.RiskAssessment != nil && k.RiskAssessment.EDDRequired && k.EDDApprovedBy != "" {
+			level = kycLevelEnhanced
+		}
+		if claimValue != kycLevelStandard && claimValue != kycLevelEnhanced {
+			return errors.New("KYC_LEVEL must be STANDARD or ENHANCED")
+		}
+		if claimValue == kycLevelEnhanced && level != kycLevelEnhanced {
+			return errors.New("Claim does not hold")
+		}
+	default:
+		return errors.New("Claim type must be AGE_OVER, RESIDENT_IN or KYC_LEVEL")
+	}
+	return nil
+}
+
+func attestation_digest(a Attestation) string {
+	return sha256_hex([]byte(a.AttestationId + "|" + a.Subject + "|" + a.ClaimType + "|" + a.ClaimValue + "|" +
+		a.SourceRequestID + "|" + a.IssuedAt + "|" + a.ExpiresAt))
+}
+
+//==============================================================================================================================
+//	 present_attestation - The subject lets one accessor verify an attestation
+//==============================================================================================================================
+func (t *SimpleChaincode) present_attestation(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		attestationId	accessorId
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := t.get_attestation_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if a.Subject != username {
+		return nil, errors.New("Permission denied. Only " + a.Subject + " can present this attestation")
+	}
+	if a.Revoked {
+		return nil, errors.New("Attestation " + args[0] + " has been revoked")
+	}
+	_, err = t.get_accessor_struct(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(presentationPrefix + a.AttestationId + "|" + args[1], []byte(now.Format(time.RFC3339)))
+	if err != nil {
+		return nil, errors.New("Error putting presentation on ledger")
+	}
+
+	return nil, t.write_audit(stub, "present_attestation", username, map[string]string{"AttestationId": a.AttestationId, "AccessorId": args[1]})
+}
+
+//==============================================================================================================================
+//	 revoke_attestation - Withdraws an attestation, by its subject or an admin
+//==============================================================================================================================
+func (t *SimpleChaincode) revoke_attestation(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		attestationId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := t.get_role(stub)
+
+	a, err := t.get_attestation_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if a.Subject != username && role != roleAdmin {
+		return nil, errors.New("Permission denied. Only " + a.Subject + " or an admin can revoke this attestation")
+	}
+
+	a.Revoked = true
+	err = t.put_attestation(stub, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, t.write_audit(stub, "revoke_attestation", a.Subject, map[string]string{"AttestationId": a.AttestationId})
+}
+
+func (t *SimpleChaincode) get_attestation_struct(stub *shim.ChaincodeStub, attestationId string) (Attestation, error) {
+
+	var a Attestation
+
+	bytes, err := stub.GetState(attestationPrefix + attestationId)
+	if err != nil {
+		return a, errors.New("Failed to get attestation " + attestationId)
+	}
+	if bytes == nil {
+		return a, errors.New("Attestation " + attestationId + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &a)
+	if err != nil {
+		return a, errors.New("Corrupt attestation " + attestationId)
+	}
+	return a, nil
+}
+
+func (t *SimpleChaincode) put_attestation(stub *shim.ChaincodeStub, a Attestation) error {
+
+	bytes, _ := json.Marshal(a)
+	err := stub.PutState(attestationPrefix + a.AttestationId, bytes)
+	if err != nil {
+		return errors.New("Error putting attestation on ledger")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 save_entity - Registers a legal entity (register_entity) or replaces it (update_entity). Updates are limited to
+//				   the registering user and admins and clear the verification, the entity has to be approved again.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_entity(stub *shim.ChaincodeStub, args []string, create bool) ([]byte, error) {
+
+	//Args
+	//			0
+	//		entity JSON object
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var e KyckEntity
+	err = json.Unmarshal([]byte(args[0]), &e)
+	if err != nil {
+		return nil, errors.New("Invalid entity JSON")
+	}
+	err = validate_entity(e, now)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := t.get_entity_struct(stub, e.EntityId)
+	if create {
+		if err == nil {
+			return nil, errors.New("Entity " + e.EntityId + " already exists")
+		}
+		e.CreatedBy = username
+	} else {
+		if err != nil {
+			return nil, err
+		}
+		role, _ := t.get_role(stub)
+		if existing.CreatedBy != username && role != roleAdmin {
+			return nil, errors.New("Permission denied. Only " + existing.CreatedBy + " or an admin can update entity " + e.EntityId)
+		}
+		e.CreatedBy = existing.CreatedBy
+	}
+
+	e.UpdatedAt = now.Format(time.RFC3339)
+	e.Verified = false
+	e.VerifiedBy = ""
+	e.SourceRequestID = ""
+
+	err = t.put_entity(stub, e)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func validate_entity(e KyckEntity, now time.Time) error {
+
+	if e.EntityId == "" || e.LegalName == "" || e.RegistrationNumber == "" {
+		return errors.New("EntityId, LegalName and RegistrationNumber are required")
+	}
+	err := validate_country("Jurisdiction", e.Jurisdiction)
+	if err != nil {
+		return err
+	}
+	if e.IncorporationDate != "" {
+		err = validate_past_date("IncorporationDate", e.IncorporationDate, now)
+		if err != nil {
+			return err
+		}
+	}
+	err = validate_address(e.RegisteredAddress)
+	if err != nil {
+		return errors.New("RegisteredAddress: " + err.Error())
+	}
+
+	if len(e.Directors) == 0 || len(e.Signatories) == 0 {
+		return errors.New("At least one director and one signatory are required")
+	}
+	for _, p := range append(append([]RelatedParty{}, e.Directors...), e.Signatories...) {
+		if p.UserId == "" {
+			return errors.New("Every director and signatory needs the UserId of their KYC package")
+		}
+	}
+
+	total := 0.0
+	for _, o := range e.BeneficialOwners {
+		if o.UserId == "" {
+			return errors.New("Every beneficial owner needs the UserId of their KYC package")
+		}
+		if o.OwnershipPercent <= 0 || o.OwnershipPercent > 100 {
+			return errors.New("OwnershipPercent of " + o.UserId + " must be above 0 and at most 100")
+		}
+		total += o.OwnershipPercent
+	}
+	if total > 100 {
+		return errors.New("Ownership of the beneficial owners adds up to more than 100 percent")
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) get_entity_struct(stub *shim.ChaincodeStub, entityId string) (KyckEntity, error) {
+
+	var e KyckEntity
+
+	bytes, err := stub.GetState(entityPrefix + entityId)
+	if err != nil {
+		return e, errors.New("Error getting entity " + entityId + " from ledger")
+	}
+	if bytes == nil {
+		return e, errors.New("Entity " + entityId + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &e)
+	if err != nil {
+		return e, errors.New("Corrupt entity " + entityId)
+	}
+	return e, nil
+}
+
+func (t *SimpleChaincode) put_entity(stub *shim.ChaincodeStub, e KyckEntity) error {
+
+	bytes, _ := json.Marshal(e)
+	err := stub.PutState(entityPrefix + e.EntityId, bytes)
+	if err != nil {
+		return errors.New("Error putting entity " + e.EntityId + " on ledger")
+	}
+	return append_history(stub, entityPrefix, e.EntityId, bytes)
+}
+
+/*
+	A corporate request must name an existing entity matching its PersonalDetails, an individual one none.
+*/
+func (t *SimpleChaincode) check_request_entity(stub *shim.ChaincodeStub, b BrokerageRequest, details PersonalDetails) error {
+
+	if details.CustomerType != customerCorporate {
+		if b.EntityId != "" {
+			return errors.New("EntityId is only allowed on " + customerCorporate + " requests")
+		}
+		return nil
+	}
+
+	if b.EntityId == "" {
+		return errors.New("EntityId is required on " + customerCorporate + " requests")
+	}
+	e, err := t.get_entity_struct(stub, b.EntityId)
+	if err != nil {
+		return err
+	}
+	if e.RegistrationNumber != details.RegistrationNumber || e.Jurisdiction != details.IncorporationCountry {
+		return errors.New("PersonalDetails do not match entity " + b.EntityId)
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 set_ubo_policy - Ownership percentage from which a beneficial owner must be verified before a corporate request
+//					  can be approved. Admin only.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_ubo_policy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		UBO policy JSON object, e.g. {"ThresholdPercent":25}
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can set the UBO policy")
+	}
+
+	var policy UBOPolicy
+	err = json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil || policy.ThresholdPercent <= 0 || policy.ThresholdPercent > 100 {
+		return nil, errors.New("Invalid UBO policy JSON")
+	}
+
+	policyAsBytes, _ := json.Marshal(policy)
+	err = stub.PutState(uboPolicyStr, policyAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting UBO policy on ledger")
+	}
+
+	return nil, t.write_audit(stub, "set_ubo_policy", "ubo", policy)
+}
+
+func (t *SimpleChaincode) get_ubo_policy(stub *shim.ChaincodeStub) (UBOPolicy, error) {
+
+	bytes, err := stub.GetState(uboPolicyStr)
+	if err != nil {
+		return UBOPolicy{}, errors.New("Failed to get " + uboPolicyStr)
+	}
+	if bytes == nil {
+		return defaultUBOPolicy, nil
+	}
+
+	var policy UBOPolicy
+	err = json.Unmarshal(bytes, &policy)
+	if err != nil {
+		return UBOPolicy{}, errors.New("Corrupt UBO policy")
+	}
+	return policy, nil
+}
+
+/*
+	Verification state of every beneficial owner of an entity. An owner at or above the threshold is required to
+	have a verified, unexpired KYC package.
+*/
+func (t *SimpleChaincode) entity_ubo_checks(stub *shim.ChaincodeStub, entityId string) ([]UBOCheck, error) {
+
+	e, err := t.get_entity_struct(stub, entityId)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := t.get_ubo_policy(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+	today := now.Format(dateLayout)
+
+	checks := []UBOCheck{}
+	for _, o := range e.BeneficialOwners {
+		c := UBOCheck{UserId: o.UserId, OwnershipPercent: o.OwnershipPercent, Required: ubo_required(o, policy)}
+
+		c.Reason = t.kyc_package_problem(stub, o.UserId, today)
+		c.Verified = c.Reason == ""
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+/**** Owners at or above the threshold need a verified KYC package ****/
+func ubo_required(o BeneficialOwner, policy UBOPolicy) bool {
+	return o.OwnershipPercent >= policy.ThresholdPercent
+}
+
+/**** Why the person's KYC package cannot be relied on, empty when it is verified and current ****/
+func (t *SimpleChaincode) kyc_package_problem(stub *shim.ChaincodeStub, userId string, today string) string {
+
+	if userId == "" {
+		return "No KYC package"
+	}
+	k, err := t.get_kyck_user_struct(stub, userId)
+	if err != nil {
+		return "No KYC package"
+	}
+	if !k.Verified {
+		return "KYC package not verified"
+	}
+	if kyc_expired(k.KYCExpiryDate, today) {
+		return "KYC package expired on " + k.KYCExpiryDate
+	}
+	return ""
+}
+
+/*
+	Approval of a corporate request: the entity still matches the request, which update_entity may have changed since
+	it was submitted, its required beneficial owners are verified, and so are all directors and signatories.
+*/
+func (t *SimpleChaincode) check_entity_for_approval(stub *shim.ChaincodeStub, b BrokerageRequest) error {
+
+	err := t.open_payload(stub, &b)
+	if err != nil {
+		return err
+	}
+	var details PersonalDetails
+	if json.Unmarshal([]byte(b.PersonalDetails), &details) != nil {
+		return errors.New("PersonalDetails of " + b.RequestID + " are not readable")
+	}
+	err = t.check_request_entity(stub, b, details)
+	if err != nil {
+		return errors.New("Cannot approve " + b.RequestID + ". " + err.Error())
+	}
+
+	checks, err := t.entity_ubo_checks(stub, b.EntityId)
+	if err != nil {
+		return err
+	}
+	for _, c := range checks {
+		if c.Required && !c.Verified {
+			return errors.New("Cannot approve " + b.RequestID + ", beneficial owner " + c.UserId + " is not verified: " + c.Reason)
+		}
+	}
+
+	e, err := t.get_entity_struct(stub, b.EntityId)
+	if err != nil {
+		return err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	today := now.Format(dateLayout)
+	for _, p := range e.Directors {
+		if problem := t.kyc_package_problem(stub, p.UserId, today); problem != "" {
+			return errors.New("Cannot approve " + b.RequestID + ", director " + p.Name + " is not verified: " + problem)
+		}
+	}
+	for _, p := range e.Signatories {
+		if problem := t.kyc_package_problem(stub, p.UserId, today); problem != "" {
+			return errors.New("Cannot approve " + b.RequestID + ", signatory " + p.Name + " is not verified: " + problem)
+		}
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 purge_expired_requests - Clears the payload columns, and the payload in the history, of closed requests past
+//							  their retention period. The skeleton (ids, parties, status, closure) stays. Admin only.
+//==============================================================================================================================
+func (t *SimpleChaincode) purge_expired_requests(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		maximum number of requests to purge in this transaction
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	max, err := strconv.Atoi(args[0])
+	if err != nil || max <= 0 {
+		return nil, errors.New("Maximum must be a positive integer")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can purge requests")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+	today := now.Format(dateLayout)
+
+	ids, err := get_index_ids(stub, applicationIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []string{}
+	for _, id := range ids {
+		if len(purged) == max {
+			break
+		}
+
+		b, err := t.get_brokerage_request_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if b.Closure == nil || b.Closure.Purged || b.Closure.PurgeAfter > today {
+			continue
+		}
+
+		err = stub.DelState(privatePrefix + b.Organisation + "|" + b.RequestID)
+		if err != nil {
+			return nil, errors.New("Error purging restricted payload of " + id)
+		}
+		purge_payload(&b)
+		b.Closure.Purged = true
+		b.Closure.PurgedAt = now.Format(time.RFC3339)
+
+		err = t.purge_history(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		err = t.replace_brokerage_request(stub, b)
+		if err != nil {
+			return nil, err
+		}
+		purged = append(purged, id)
+	}
+
+	err = t.write_audit(stub, "purge_expired_requests", "retention", purged)
+	if err != nil {
+		return nil, err
+	}
+
+	purgedAsBytes, _ := json.Marshal(purged)
+	return purgedAsBytes, nil
+}
+
+func purge_payload(b *BrokerageRequest) {
+	b.Documents = ""
+	b.PersonalDetails = ""
+	b.KYCDetails = ""
+	b.DocValidationReport = ""
+	b.FacialValidation = ""
+	b.Video = ""
+	b.Meeting = ""
+}
+
+func (t *SimpleChaincode) purge_history(stub *shim.ChaincodeStub, requestId string) error {
+	return t.rewrite_history(stub, requestId, purge_payload)
+}
+
+/*
+	Applies change to every history snapshot of a brokerage request.
+*/
+func (t *SimpleChaincode) rewrite_history(stub *shim.ChaincodeStub, requestId string, change func(b *BrokerageRequest)) error {
+
+	keys, values, err := range_by_prefix(stub, history_key_prefix(applicationIndexStr, requestId))
+	if err != nil {
+		return err
+	}
+
+	for i := range keys {
+		var entry HistoryEntry
+		var snapshot BrokerageRequest
+		if json.Unmarshal(values[i], &entry) != nil || json.Unmarshal(entry.Value, &snapshot) != nil {
+			return errors.New("Corrupt history entry " + keys[i])
+		}
+
+		change(&snapshot)
+		entry.Value, _ = json.Marshal(snapshot)
+
+		entryAsBytes, _ := json.Marshal(entry)
+		err = stub.PutState(keys[i], entryAsBytes)
+		if err != nil {
+			return errors.New("Error purging history entry " + keys[i])
+		}
+	}
+	return nil
+}
+
+/*
+	Replaces the personal details in the history of a user by their hash, keyed with the salt of the user's
+	current restricted record.
+*/
+func (t *SimpleChaincode) rewrite_user_history(stub *shim.ChaincodeStub, userId string) error {
+
+	var record RestrictedRecord
+	bytes, err := stub.GetState(privateUserPrefix + userId)
+	if err != nil || json.Unmarshal(bytes, &record) != nil {
+		return errors.New("Failed to get personal details of " + userId)
+	}
+
+	keys, values, err := range_by_prefix(stub, history_key_prefix(usersIndexStr, userId))
+	if err != nil {
+		return err
+	}
+
+	for i := range keys {
+		var entry HistoryEntry
+		var snapshot map[string]json.RawMessage
+		if json.Unmarshal(values[i], &entry) != nil || json.Unmarshal(entry.Value, &snapshot) != nil {
+			return errors.New("Corrupt history entry " + keys[i])
+		}
+		details := snapshot["personalDetails"]
+		if details == nil {
+			continue
+		}
+
+		delete(snapshot, "personalDetails")
+		if string(details) != "null" {
+			snapshot["personalDetailsHash"], _ = json.Marshal(sealed_value(record.Salt, string(details)))
+		}
+		entry.Value, _ = json.Marshal(snapshot)
+
+		entryAsBytes, _ := json.Marshal(entry)
+		err = stub.PutState(keys[i], entryAsBytes)
+		if err != nil {
+			return errors.New("Error rewriting history entry " + keys[i])
+		}
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 import_users - Creates a batch of users for migrations. Admin only. Every item is validated before anything is
+//					written; in all_or_nothing mode one bad item fails the batch, in partial mode the good items are
+//					written and the bad ones reported. The result is also kept in the audit log.
+//==============================================================================================================================
+func (t *SimpleChaincode) import_users(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0							1
+	//		JSON array of user objects		"all_or_nothing" or "partial"
+
+	items, result, err := t.start_batch(stub, args)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Validate everything first ****/
+	ids := make([]string, len(items))
+	valid := make([]bool, len(items))
+	seen := map[string]bool{}
+	for i, item := range items {
+		var u User
+		if json.Unmarshal(item, &u) != nil {
+			result.Failures = append(result.Failures, BatchFailure{Index: i, Error: "Invalid user JSON"})
+			continue
+		}
+		ids[i] = u.UserId
+
+		problem := ""
+		if u.UserId == "" {
+			problem = "userId is required"
+		} else if err := check_record_id(u.UserId); err != nil {
+			problem = err.Error()
+		} else if err := validate_user(u, now); err != nil {
+			problem = err.Error()
+		} else if seen[u.UserId] {
+			problem = "Duplicate userId in batch"
+		} else {
+			existing, err := stub.GetState(u.UserId)
+			if err != nil {
+				return nil, errors.New("Failed to get " + u.UserId)
+			}
+			if existing != nil {
+				problem = u.UserId + " already exists"
+			}
+		}
+		seen[u.UserId] = true
+
+		if problem != "" {
+			result.Failures = append(result.Failures, BatchFailure{Index: i, Id: u.UserId, Error: problem})
+			continue
+		}
+		valid[i] = true
+	}
+
+	err = check_batch(result)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Then write the valid items ****/
+	for i, item := range items {
+		if !valid[i] {
+			continue
+		}
+		err = create_record(stub, usersIndexStr, ids[i], item)
+		if err != nil {
+			if result.Mode == batchAllOrNothing {
+				return nil, err
+			}
+			result.Failures = append(result.Failures, BatchFailure{Index: i, Id: ids[i], Error: err.Error()})
+			continue
+		}
+		result.Succeeded = append(result.Succeeded, ids[i])
+	}
+
+	return t.finish_batch(stub, "import_users", result)
+}
+
+//==============================================================================================================================
+//	 import_brokerage_requests - Creates a batch of brokerage requests for migrations, same modes as import_users.
+//								 Admin only. Open, rejected and withdrawn statuses are kept, anything else,
+//								 including APPROVED, is imported as SUBMITTED.
+//==============================================================================================================================
+func (t *SimpleChaincode) import_brokerage_requests(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0										1
+	//		JSON array of brokerage request objects		"all_or_nothing" or "partial"
+
+	items, result, err := t.start_batch(stub, args)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Validate everything first ****/
+	requests := make([]BrokerageRequest, len(items))
+	valid := make([]bool, len(items))
+	seen := map[string]bool{}
+	for i, item := range items {
+		var b BrokerageRequest
+		if json.Unmarshal(item, &b) != nil {
+			result.Failures = append(result.Failures, BatchFailure{Index: i, Error: "Invalid brokerage request JSON"})
+			continue
+		}
+
+		problem := ""
+		if b.RequestID == "" || b.Submitter == "" || b.Approver == "" {
+			problem = "RequestID, Submitter and Approver are required"
+		} else if err := check_record_id(b.RequestID); err != nil {
+			problem = err.Error()
+		} else if err := t.check_request_approver(stub, b.Approver, b.Submitter); err != nil {
+			problem = err.Error()
+		} else if details, err := normalize_request_details(&b, now); err != nil {
+			problem = err.Error()
+		} else if err := t.check_request_entity(stub, b, details); err != nil {
+			problem = err.Error()
+		} else if seen[b.RequestID] {
+			problem = "Duplicate RequestID in batch"
+		} else {
+			row, err := stub.GetRow("BrokerageRequests", []shim.Column{shim.Column{Value: &shim.Column_String_{String_: b.RequestID}}})
+			if err != nil {
+				return nil, errors.New("Error getting brokerage request " + b.RequestID)
+			}
+			if len(row.Columns) > 0 {
+				problem = "Brokerage request " + b.RequestID + " already exists"
+			}
+		}
+		seen[b.RequestID] = true
+
+		if problem != "" {
+			result.Failures = append(result.Failures, BatchFailure{Index: i, Id: b.RequestID, Error: problem})
+			continue
+		}
+
+		/**** Same rules as create_brokerage_request, apart from the status. An approval has to pass the approval
+			  checks on this ledger, approved requests come in as submitted. ****/
+		b.DocValidationReport = ""
+		b.KYCPackageRef = ""
+		b.Closure = nil
+		if !is_open_status(b.Status) && b.Status != statusRejected && b.Status != statusWithdrawn {
+			b.Status = statusSubmitted
+		}
+		if b.TimeStamps == "" {
+			b.TimeStamps = submit_time_stamps(now)
+		}
+
+		requests[i] = b
+		valid[i] = true
+	}
+
+	err = check_batch(result)
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Then write the valid items ****/
+	for i := range items {
+		if !valid[i] {
+			continue
+		}
+		err = t.insert_brokerage_request(stub, requests[i])
+		if err != nil {
+			if result.Mode == batchAllOrNothing {
+				return nil, err
+			}
+			result.Failures = append(result.Failures, BatchFailure{Index: i, Id: requests[i].RequestID, Error: err.Error()})
+			continue
+		}
+		result.Succeeded = append(result.Succeeded, requests[i].RequestID)
+	}
+
+	return t.finish_batch(stub, "import_brokerage_requests", result)
+}
+
+/*
+	Checks the caller, the mode and the batch size and parses the items.
+*/
+func (t *SimpleChaincode) start_batch(stub *shim.ChaincodeStub, args []string) ([]json.RawMessage, BatchResult, error) {
+
+	var result BatchResult
+
+	if len(args) != 2 {
+		return nil, result, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, result, err
+	}
+	if role != roleAdmin {
+		return nil, result, errors.New("Permission denied. Only an admin can import")
+	}
+
+	return parse_batch(args[0], args[1])
+}
+
+/**** The items of a batch in one of the modes, at least one and at most maxBatchSize ****/
+func parse_batch(batch string, mode string) ([]json.RawMessage, BatchResult, error) {
+
+	var result BatchResult
+
+	if mode != batchAllOrNothing && mode != batchPartial {
+		return nil, result, errors.New("Mode must be " + batchAllOrNothing + " or " + batchPartial)
+	}
+
+	var items []json.RawMessage
+	err := json.Unmarshal([]byte(batch), &items)
+	if err != nil {
+		return nil, result, errors.New("Batch must be a JSON array")
+	}
+	if len(items) == 0 {
+		return nil, result, errors.New("Batch is empty")
+	}
+	if len(items) > maxBatchSize {
+		return nil, result, errors.New("Batch has " + strconv.Itoa(len(items)) + " items, the maximum is " + strconv.Itoa(maxBatchSize))
+	}
+
+	result.Mode = mode
+	result.Total = len(items)
+	result.Succeeded = []string{}
+	result.Failures = []BatchFailure{}
+	return items, result, nil
+}
+
+func check_batch(result BatchResult) error {
+
+	if result.Mode == batchAllOrNothing && len(result.Failures) > 0 {
+		failuresAsBytes, _ := json.Marshal(result.Failures)
+		return errors.New("Batch rejected, nothing was written: " + string(failuresAsBytes))
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) finish_batch(stub *shim.ChaincodeStub, action string, result BatchResult) ([]byte, error) {
+
+	err := t.write_audit(stub, action, "import", result)
+	if err != nil {
+		return nil, err
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return resultAsBytes, nil
+}
+
+//==============================================================================================================================
+//	 record_dossier_export - Builds the KYC dossier of a customer inside the transaction and keeps its manifest and
+//							 package hash on the ledger, so the package handed out by get_kyc_dossier can be verified
+//							 later with verify_kyc_dossier. Same callers as get_kyc_dossier. The recorded hash is
+//							 the only anchor, chaincode holds no key to sign the package with.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_dossier_export(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		userId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	dossier, err := t.build_kyc_dossier(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	username, _ := t.get_username(stub)
+	export := DossierExport{
+		UserId:      dossier.UserId,
+		PackageHash: dossier.PackageHash,
+		Manifest:    dossier.Manifest,
+		ExportedBy:  username,
+		ExportedAt:  dossier.GeneratedAt,
+	}
+	exportAsBytes, _ := json.Marshal(export)
+	err = stub.PutState(dossierPrefix + dossier.PackageHash, exportAsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting dossier export on ledger")
+	}
+
+	err = t.write_audit(stub, "record_dossier_export", dossier.UserId, export)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(dossier.PackageHash), nil
+}
+
+/*
+	Assembles profile, KYC package, document metadata, brokerage requests, risk assessments, screenings, consent
+	grants and audit entries of a customer. Only the customer, an admin, or a regulator whose organisation handles
+	the customer's requests may do this. Personal details and PII are opened as elsewhere: the profile for the
+	customer and admins, the KYC package and requests for the members of the request they came with.
+*/
+func (t *SimpleChaincode) build_kyc_dossier(stub *shim.ChaincodeStub, userId string) (KYCDossier, error) {
+
+	dossier := KYCDossier{UserId: userId, Sections: map[string]json.RawMessage{}, Manifest: map[string]string{}}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return dossier, err
+	}
+	role, _ := t.get_role(stub)
+	isRegulator, err := t.caller_is_regulator(stub)
+	if err != nil {
+		return dossier, err
+	}
+	scope, err := t.caller_scope(stub)
+	if err != nil {
+		return dossier, err
+	}
+	if username != userId && role != roleAdmin {
+		customers, err := org_customers(stub, scope)
+		if err != nil {
+			return dossier, err
+		}
+		if !isRegulator || (!scope.All && !customers[userId]) {
+			return dossier, errors.New("Permission denied. Only the customer, a regulator of their organisation or an admin can export a dossier")
+		}
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return dossier, err
+	}
+	dossier.GeneratedAt = now.Format(time.RFC3339)
+
+	sections := map[string]interface{}{}
+
+	/**** Profile, without the password material, and for everybody else the hash of the personal details ****/
+	var u User
+	if username == userId || role == roleAdmin {
+		u, err = t.get_user_struct(stub, userId)
+	} else {
+		u, err = t.get_stored_user(stub, userId)
+		u.PersonalDetails = nil
+	}
+	if err == nil {
+		u.Salt = ""
+		u.Hash = ""
+		sections["profile"] = u
+	} else {
+		sections["profile"] = nil
+	}
+
+	/**** KYC package, the documents themselves are referenced by the documents section ****/
+	k, err := t.get_stored_kyck_user(stub, userId)
+	if err == nil && t.kyc_package_member(stub, &scope, k) {
+		k, err = t.get_kyck_user_struct(stub, userId)
+	}
+	if err == nil {
+		k.Documents = nil
+		sections["kycPackage"] = k
+	} else {
+		sections["kycPackage"] = nil
+	}
+
+	keys, values, err := range_by_prefix(stub, resourcePrefix + userId + "|")
+	if err != nil {
+		return dossier, err
+	}
+	resources := []DossierResource{}
+	for i := range keys {
+		resources = append(resources, DossierResource{Hash: keys[i][len(resourcePrefix + userId + "|"):], Path: string(values[i])})
+	}
+	sections["documents"] = resources
+
+	/**** Brokerage requests submitted by the customer with their risk assessments and audit entries, for a
+	      regulator those of their organisation unless they work across organisations ****/
+	_, ids, err := range_by_prefix(stub, userRequestPrefix + userId + "|")
+	if err != nil {
+		return dossier, err
+	}
+	requests := []BrokerageRequest{}
+	audit, err := t.get_audit_entries(stub, userId)
+	if err != nil {
+		return dossier, err
+	}
+	for _, requestId := range ids {
+		id := string(requestId)
+		b, err := t.get_brokerage_request_struct(stub, id)
+		if err != nil {
+			return dossier, err
+		}
+		if b.Submitter != userId || !t.request_visible(stub, &scope, b) {
+			continue
+		}
+		if t.payload_member(&scope, b) {
+			err = t.open_payload(stub, &b)
+			if err != nil {
+				return dossier, err
+			}
+		}
+		b.Risk, err = t.get_risk_assessment(stub, id)
+		if err != nil {
+			return dossier, err
+		}
+		requests = append(requests, b)
+
+		requestAudit, err := t.get_audit_entries(stub, id)
+		if err != nil {
+			return dossier, err
+		}
+		audit = append(audit, requestAudit...)
+	}
+	sections["brokerageRequests"] = requests
+	sections["auditEntries"] = audit
+
+	/**** Screenings and consent grants ****/
+	_, screeningIds, err := range_by_prefix(stub, userScreeningPrefix + userId + "|")
+	if err != nil {
+		return dossier, err
+	}
+	screenings := []ScreeningResult{}
+	for _, screeningId := range screeningIds {
+		r, err := t.get_screening_struct(stub, string(screeningId))
+		if err != nil {
+			return dossier, err
+		}
+		screenings = append(screenings, r)
+	}
+	sections["screenings"] = screenings
+
+	keys, values, err = range_by_prefix(stub, consentPrefix + userId + "|")
+	if err != nil {
+		return dossier, err
+	}
+	consents := []KYCConsent{}
+	for i := range keys {
+		var c KYCConsent
+		if json.Unmarshal(values[i], &c) == nil && c.UserId == userId {
+			consents = append(consents, c)
+		}
+	}
+	sections["consents"] = consents
+
+	/**** Hash every section, then the manifest ****/
+	for name, section := range sections {
+		canonical, err := canonical_json(section)
+		if err != nil {
+			return dossier, err
+		}
+		dossier.Sections[name] = json.RawMessage(canonical)
+		dossier.Manifest[name] = sha256_hex(canonical)
+	}
+
+	manifest, _ := json.Marshal(dossier.Manifest)
+	dossier.PackageHash = sha256_hex(manifest)
+
+	return dossier, nil
+}
+
+/**** The customer and the members of the request a KYC package was verified on read its PII ****/
+func (t *SimpleChaincode) kyc_package_member(stub *shim.ChaincodeStub, scope *CallerScope, k KyckUser) bool {
+
+	if k.UserId == scope.UserId {
+		return true
+	}
+	if k.SourceRequestID == "" {
+		return false
+	}
+	b, err := t.get_brokerage_request_struct(stub, k.SourceRequestID)
+	return err == nil && t.payload_member(scope, b)
+}
+
+/*
+	Re-encodes a value so the same content always gives the same bytes: object keys sorted, no whitespace,
+	numbers kept as written.
+*/
+func canonical_json(v interface{}) ([]byte, error) {
+
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.New("Could not convert to JSON")
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(bytes)))
+	decoder.UseNumber()
+	var generic interface{}
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return nil, errors.New("Could not convert to JSON")
+	}
+	return json.Marshal(generic)
+}
+
+func sha256_hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+	HMAC-SHA256 hex of userId|channel|code keyed with the hex salt of the issued code. An unreadable salt gives
+	a hash nothing matches.
+*/
+func code_hash(salt string, userId string, channel string, code string) string {
+	key, err := hex.DecodeString(salt)
+	if err != nil || len(key) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(userId + "|" + channel + "|" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+	Verdict for a single document: FAIL on a failed MRZ/checksum, any tamper flag or an expired document,
+	REFER when something could not be established, PASS otherwise.
+*/
+func document_check_verdict(check DocumentCheck, now time.Time) string {
+
+	if check.MRZVerdict == verdictFail || len(check.TamperFlags) > 0 {
+		return verdictFail
+	}
+
+	if check.ExpiryDate != "" {
+		expiry, err := time.Parse("2006-01-02", check.ExpiryDate)
+		if err != nil {
+			return verdictRefer
+		}
+		if !now.Before(expiry.AddDate(0, 0, 1)) {
+			return verdictFail
+		}
+	}
+
+	if check.DocumentType == "" || check.IssuingCountry == "" || check.ExpiryDate == "" {
+		return verdictRefer
+	}
+	if check.MRZVerdict != verdictPass && check.MRZVerdict != verdictNotApplicable {
+		return verdictRefer
+	}
+	return verdictPass
+}
+
+/*
+	Aggregate verdict over all checked documents: any FAIL fails the request, otherwise any REFER refers it.
+*/
+func aggregate_verdict(checks []DocumentCheck) string {
+
+	if len(checks) == 0 {
+		return verdictPending
+	}
+
+	verdict := verdictPass
+	for _, c := range checks {
+		if c.Verdict == verdictFail {
+			return verdictFail
+		}
+		if c.Verdict == verdictRefer {
+			verdict = verdictRefer
+		}
+	}
+	return verdict
+}
+
+/*
+	Parses the PersonalDetails and KYCDetails of a brokerage request, validates them and stores them back in their
+	canonical shape.
+*/
+func normalize_request_details(b *BrokerageRequest, now time.Time) (PersonalDetails, error) {
+
+	var p PersonalDetails
+	err := decode_with_extensions([]byte(b.PersonalDetails), &p, &p.Extensions)
+	if err != nil {
+		return p, errors.New("Invalid PersonalDetails JSON")
+	}
+	var k KYCDetails
+	err = decode_with_extensions([]byte(b.KYCDetails), &k, &k.Extensions)
+	if err != nil {
+		return p, errors.New("Invalid KYCDetails JSON")
+	}
+
+	err = validate_personal_details(p, now)
+	if err != nil {
+		return p, err
+	}
+	err = validate_kyc_details(k, p.CustomerType, now)
+	if err != nil {
+		return p, err
+	}
+
+	pAsBytes, _ := json.Marshal(p)
+	kAsBytes, _ := json.Marshal(k)
+	b.PersonalDetails = string(pAsBytes)
+	b.KYCDetails = string(kAsBytes)
+	return p, nil
+}
+
+/*
+	Unmarshals data into v and moves every top-level field v does not define into extensions.
+*/
+func decode_with_extensions(data []byte, v interface{}, extensions *map[string]interface{}) error {
+
+	if len(data) == 0 {
+		data = []byte("{}")
+	}
+
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]interface{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	knownAsBytes, _ := json.Marshal(v)
+	var known map[string]interface{}
+	json.Unmarshal(knownAsBytes, &known)
+
+	for key, value := range raw {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		if *extensions == nil {
+			*extensions = map[string]interface{}{}
+		}
+		(*extensions)[key] = value
+	}
+	return nil
+}
+
+/*
+	Validates the personalDetails of a user JSON object when present.
+*/
+func (t *SimpleChaincode) check_user_json(stub *shim.ChaincodeStub, userJson string) error {
+
+	var u User
+	err := json.Unmarshal([]byte(userJson), &u)
+	if err != nil {
+		return errors.New("Invalid user JSON")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	return validate_user(u, now)
+}
+
+func validate_user(u User, now time.Time) error {
+
+	if u.PersonalDetails == nil {
+		return nil
+	}
+	return validate_personal_details(*u.PersonalDetails, now)
+}
+
+/*
+	Required fields depend on the customer type: individuals need name, date of birth, nationality and a residential
+	address, corporates need legal name, registration number, country of incorporation and a registered address.
+*/
+func validate_personal_details(p PersonalDetails, now time.Time) error {
+
+	var registered, residential bool
+	for i, a := range p.Addresses {
+		err := validate_address(a)
+		if err != nil {
+			return errors.New("Addresses[" + strconv.Itoa(i) + "]: " + err.Error())
+		}
+		registered = registered || a.Type == addressRegistered
+		residential = residential || a.Type == addressResidential
+	}
+
+	switch p.CustomerType {
+	case customerIndividual:
+		if p.FirstName == "" || p.LastName == "" {
+			return errors.New("FirstName and LastName are required")
+		}
+		err := validate_past_date("DateOfBirth", p.DateOfBirth, now)
+		if err != nil {
+			return err
+		}
+		err = validate_country("Nationality", p.Nationality)
+		if err != nil {
+			return err
+		}
+		if !residential {
+			return errors.New("A RESIDENTIAL address is required")
+		}
+	case customerCorporate:
+		if p.LegalName == "" || p.RegistrationNumber == "" {
+			return errors.New("LegalName and RegistrationNumber are required")
+		}
+		err := validate_country("IncorporationCountry", p.IncorporationCountry)
+		if err != nil {
+			return err
+		}
+		if p.IncorporationDate != "" {
+			err = validate_past_date("IncorporationDate", p.IncorporationDate, now)
+			if err != nil {
+				return err
+			}
+		}
+		if !registered {
+			return errors.New("A REGISTERED address is required")
+		}
+	default:
+		return errors.New("CustomerType must be " + customerIndividual + " or " + customerCorporate)
+	}
+	return nil
+}
+
+func validate_address(a Address) error {
+
+	if a.Type != addressResidential && a.Type != addressMailing && a.Type != addressRegistered {
+		return errors.New("Type must be RESIDENTIAL, MAILING or REGISTERED")
+	}
+	if a.Line1 == "" || a.City == "" {
+		return errors.New("Line1 and City are required")
+	}
+	return validate_country("Country", a.Country)
+}
+
+/*
+	Individuals need an unexpired identity document, corporates do not. Everyone needs a tax residency and a
+	source of funds.
+*/
+func validate_kyc_details(k KYCDetails, customerType string, now time.Time) error {
+
+	if customerType == customerIndividual && len(k.IdDocuments) == 0 {
+		return errors.New("At least one identity document is required")
+	}
+	for i, d := range k.IdDocuments {
+		field := "IdDocuments[" + strconv.Itoa(i) + "]"
+		if d.Type == "" || d.Number == "" {
+			return errors.New(field + ": Type and Number are required")
+		}
+		err := validate_country(field + ".IssuingCountry", d.IssuingCountry)
+		if err != nil {
+			return err
+		}
+		if d.IssueDate != "" {
+			err = validate_past_date(field + ".IssueDate", d.IssueDate, now)
+			if err != nil {
+				return err
+			}
+		}
+		expiry, err := time.Parse(dateLayout, d.ExpiryDate)
+		if err != nil {
+			return errors.New(field + ".ExpiryDate must be a date in YYYY-MM-DD format")
+		}
+		if !now.Before(expiry.AddDate(0, 0, 1)) {
+			return errors.New(field + " has expired")
+		}
+		if d.IssueDate != "" && d.ExpiryDate <= d.IssueDate {
+			return errors.New(field + ".ExpiryDate must be after IssueDate")
+		}
+	}
+
+	if len(k.TaxResidencies) == 0 {
+		return errors.New("At least one tax residency is required")
+	}
+	for i, r := range k.TaxResidencies {
+		err := validate_country("TaxResidencies[" + strconv.Itoa(i) + "].Country", r.Country)
+		if err != nil {
+			return err
+		}
+	}
+
+	if customerType == customerIndividual && k.Occupation == "" {
+		return errors.New("Occupation is required")
+	}
+	if k.SourceOfFunds == "" {
+		return errors.New("SourceOfFunds is required")
+	}
+	return nil
+}
+
+func validate_country(field string, code string) error {
+
+	if !countryCodes[code] {
+		return errors.New(field + " must be an ISO 3166-1 alpha-2 country code")
+	}
+	return nil
+}
+
+func validate_past_date(field string, value string, now time.Time) error {
+
+	date, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return errors.New(field + " must be a date in YYYY-MM-DD format")
+	}
+	if date.After(now) {
+		return errors.New(field + " cannot be in the future")
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) toJson()(string){
+	var returnValue string;
+	
+	return returnValue
+}
+
+
+
+/*
+	Return the struct from the table.
+*/
+func(t *SimpleChaincode) getStructFromRow(row shim.Row)(BrokerageRequest){
+	
+	var brokerageRequest BrokerageRequest
+	for index := range row.Columns {
+		column := row.Columns[index]
+		if index == 0 {
+			brokerageRequest.RequestID = column.GetString_()
+		}else if index == 1 {
+			brokerageRequest.Submitter = column.GetString_()
+		}else if index == 2 {
+			brokerageRequest.Approver = column.GetString_()
+		}else if index == 3 {
+			brokerageRequest.Documents = string(column.GetBytes())
+		}else if index == 4 {
+			brokerageRequest.PersonalDetails = string(column.GetBytes())
+		}else if index == 5 {
+			brokerageRequest.KYCDetails = string(column.GetBytes())
+		}else if index == 6 {
+			brokerageRequest.Status = stored_status(column.GetString_())
+		}else if index == 7 {
+			brokerageRequest.DocValidationReport = string(column.GetBytes())
+		}else if index == 8 {
+			brokerageRequest.FacialValidation = string(column.GetBytes())
+		}else if index == 9 {
+			brokerageRequest.Video = string(column.GetBytes())
+		}else if index == 10 {
+			brokerageRequest.TimeStamps = string(column.GetBytes())
+		}else if index == 11 {
+			brokerageRequest.Meeting = column.GetString_()
+		}else if index == 12 {
+			brokerageRequest.KYCPackageRef = column.GetString_()
+		}else if index == 13 {
+			if len(column.GetBytes()) > 0 {
+				brokerageRequest.Closure = &RequestClosure{}
+				json.Unmarshal(column.GetBytes(), brokerageRequest.Closure)
+			}
+		}else if index == 14 {
+			brokerageRequest.EntityId = column.GetString_()
+		}else if index == 15 {
+			brokerageRequest.Organisation = column.GetString_()
+		}
+		index ++
+	}
+
+	/**** Surface the aggregate document verdict next to the report ****/
+	brokerageRequest.DocVerdict = verdictPending
+	if brokerageRequest.DocValidationReport != "" {
+		var report DocumentValidationReport
+		if json.Unmarshal([]byte(brokerageRequest.DocValidationReport), &report) == nil {
+			brokerageRequest.DocVerdict = report.Verdict
+		}
+	}
+	return brokerageRequest
+}
+
+/*
+	The Status column holds the status value. STATUS updates used to store the whole update JSON in it, rows written
+	that way read as the Status of that JSON.
+*/
+func stored_status(column string) string {
+
+	if !strings.HasPrefix(strings.TrimSpace(column), "{") {
+		return column
+	}
+	var update struct {
+		Status string `json:"Status"`
+	}
+	if json.Unmarshal([]byte(column), &update) != nil {
+		return column
+	}
+	return update.Status
+}
+
+/*This function helps in getting the data stored from local database*/
+func (t *SimpleChaincode) fetch_from_table(stub *shim.ChaincodeStub, requestId string) (shim.Row, error) {
+
+	var columns []shim.Column
+	columns = append(columns, shim.Column{Value: &shim.Column_String_{String_: requestId}})
+
+	row, err := stub.GetRow("BrokerageRequests", columns)
+	if err != nil {
+		return row, errors.New("Error getting brokerage request " + requestId)
+	}
+	if len(row.Columns) == 0 {
+		return row, errors.New("Brokerage request " + requestId + " does not exist")
+	}
+	return row, nil
+}
+
+
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_user(stub *shim.ChaincodeStub, userID string) ([]byte, error) {
+
+	/**** Only users, not whatever else is stored under the given key ****/
+	allowed, err := t.raw_read_allowed(stub, usersIndexStr, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, nil
+	}
+
+	/**** The user and admins read the personal details, everybody else their hash ****/
+	username, _ := t.get_username(stub)
+	role, _ := t.get_role(stub)
+	if username == userID || role == roleAdmin {
+		u, err := t.get_user_struct(stub, userID)
+		if err != nil {
+			return nil, err
+		}
+		bytes, _ := json.Marshal(u)
+		return bytes, nil
+	}
+
+	u, err := t.get_stored_user(stub, userID)
+	if err != nil {
+		return nil, errors.New("Could not retrieve information for this user")
+	}
+
+	/**** and never the password material, as in query_users ****/
+	u.Salt = ""
+	u.Hash = ""
+	bytes, _ := json.Marshal(u)
+	return bytes, nil
+
+}
+
+/**** get_user and get_thing read a bare key, which has to be a record of their index and not one of the chaincode's
+      own records, e.g. a restricted payload. Anything else reads as not found. ****/
+func (t *SimpleChaincode) raw_read_allowed(stub *shim.ChaincodeStub, indexStr string, id string) (bool, error) {
+
+	if is_reserved_key(id) {
+		return false, errors.New("Permission denied. " + id + " is in a reserved keyspace")
+	}
+	return index_contains(stub, indexStr, id)
+}
+
+func (t *SimpleChaincode) get_thing(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		thingID
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	allowed, err := t.raw_read_allowed(stub, thingsIndexStr, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, nil
+	}
+
+	bytes, err := stub.GetState(args[0])
+
+	if err != nil {
+		return nil, errors.New("Error getting from ledger")
+	}
+
+	return bytes, nil
+
+}
+
+func (t *SimpleChaincode) get_all_things(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	thingsIndex, err := get_index_ids(stub, thingsIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var things []Thing
+	for _, thing := range thingsIndex {
+
+		bytes, err := stub.GetState(thing)
+		if err != nil {
+			return nil, errors.New("Unable to get thing with ID: " + thing)
+		}
+
+		var t Thing
+		err = json.Unmarshal(bytes, &t)
+		if err != nil {
+			return nil, errors.New("Corrupt thing with ID: " + thing)
+		}
+		things = append(things, t)
+	}
+
+	thingsAsJsonBytes, _ := json.Marshal(things)
+	if err != nil {
+		return nil, errors.New("Could not convert things to JSON ")
+	}
+
+	return thingsAsJsonBytes, nil
+}
+
+func (t *SimpleChaincode) query_things(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		listing query JSON object (as string), see ListingQuery
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	q, err := parse_listing_query(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := run_listing(stub, thingsIndexStr, q, func(id string) (interface{}, error) {
+		return t.get_thing_struct(stub, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(page)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) query_users(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		listing query JSON object (as string), see ListingQuery
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	q, err := parse_listing_query(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	/**** Outside the cross-org scope, users show up for the organisations handling their requests ****/
+	scope, err := t.caller_scope(stub)
+	if err != nil {
+		return nil, err
+	}
+	customers, err := org_customers(stub, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := run_listing(stub, usersIndexStr, q, func(id string) (interface{}, error) {
+		if !scope.All && !customers[id] && id != scope.UserId {
+			return nil, nil
+		}
+		u, err := t.get_user_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		// Never hand out the password material, not even to select on it, nor somebody else's personal details
+		u.Salt = ""
+		u.Hash = ""
+		if id != scope.UserId {
+			u.PersonalDetails = nil
+		}
+		return u, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(page)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) query_brokerage_requests(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		listing query JSON object (as string), see ListingQuery
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	q, err := parse_listing_query(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if q.IncludeClosed {
+		isRegulator, err := t.caller_is_regulator(stub)
+		if err != nil {
+			return nil, err
+		}
+		if !isRegulator {
+			return nil, errors.New("Permission denied. Only regulators can list closed brokerage requests")
+		}
+	}
+
+	scope, err := t.caller_scope(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := run_listing(stub, applicationIndexStr, q, func(id string) (interface{}, error) {
+		b, err := t.get_brokerage_request_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if b.Closure != nil && !q.IncludeClosed {
+			return nil, nil
+		}
+		if !t.request_visible(stub, &scope, b) {
+			return nil, nil
+		}
+		if t.payload_member(&scope, b) {
+			err = t.open_payload(stub, &b)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(page)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_things_by_owner(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		userID
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	_, err := t.get_user_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	/**** The Owner of the things decides, the user's Things list is only a copy ****/
+	thingIds, err := get_index_ids(stub, thingsIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	things := []Thing{}
+	for _, id := range thingIds {
+		thing, err := t.get_thing_struct(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if thing.Owner == args[0] {
+			things = append(things, thing)
+		}
+	}
+
+	thingsAsJsonBytes, _ := json.Marshal(things)
+	return thingsAsJsonBytes, nil
+}
+
+func (t *SimpleChaincode) authenticate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	// Args
+	//	0		1
+	//	userId	password
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	var u User
+
+	username := args[0]
+
+	user, err := t.get_user(stub, username)
+
+	// If user can not be found in ledgerstore, return authenticated false
+	if err != nil {
+		return []byte(`{ "authenticated": false }`), nil
+	}
+
+	//Check if the user is an employee, if not return error message
+	err = json.Unmarshal(user, &u)
+	if err != nil {
+		return []byte(`{ "authenticated": false}`), nil
+	}
+
+	// Marshal the user object
+	userAsBytes, err := json.Marshal(u)
+	if err != nil {
+		return []byte(`{ "authenticated": false}`), nil
+	}
+
+	// Return authenticated true, and include the user object
+	str := `{ "authenticated": true, "user": ` + string(userAsBytes) + `  }`
+
+	return []byte(str), nil
+}
+
+
+func (t *SimpleChaincode) add_resource(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2
+	//		  owner		resource id		path
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+
+    id := args[0] + args[1]
+	err := check_record_id(id)
+	if err != nil {
+		return nil, err
+	}
+
+    err = stub.PutState(string(id), []byte(args[2]))
+	if err != nil {
+		return nil, errors.New("Error putting resource data on ledger")
+	}
+
+	/**** The owner's resources are listed in their KYC dossier ****/
+	err = stub.PutState(resourcePrefix + args[0] + "|" + args[1], []byte(args[2]))
+	if err != nil {
+		return nil, errors.New("Error putting resource index on ledger")
+	}
+    return nil, nil   
+
+}
+
+func (t *SimpleChaincode) get_resource(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1
+	//		  owner		resource id
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+    id := args[0] + args[1]
+	if is_reserved_key(id) {
+		return nil, errors.New("Permission denied. " + id + " is not a resource")
+	}
+
+    path, err := stub.GetState(string(id))
+	if err != nil {
+		return nil, errors.New("Error getting resource data from ledger")
+	}
+
+	/**** Documents sent in response to a request for information are only stored under the resources prefix ****/
+	if path == nil {
+		path, err = stub.GetState(resourcePrefix + args[0] + "|" + args[1])
+		if err != nil {
+			return nil, errors.New("Error getting resource data from ledger")
+		}
+	}
+    return path, nil
+
+    
+}
+
+func (t *SimpleChaincode) get_brokerage_request(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		requestId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	 requestId := args[0]
+	 structure, err := t.get_brokerage_request_struct(stub, requestId)
+	 if err != nil {
+		 return nil, err
+	 }
+
+	 scope, err := t.caller_scope(stub)
+	 if err != nil {
+		 return nil, err
+	 }
+	 err = t.check_request_readable(stub, &scope, structure)
+	 if err != nil {
+		 return nil, err
+	 }
+	 if t.payload_member(&scope, structure) {
+		 err = t.open_payload(stub, &structure)
+		 if err != nil {
+			 return nil, err
+		 }
+	 }
+
+	 structure.Risk, _ = t.get_risk_assessment(stub, requestId)
+	 structure.InfoRequests, err = t.get_info_requests(stub, requestId)
+	 if err != nil {
+		 return nil, err
+	 }
+	 bytesArray,_ := json.Marshal(structure)
+	 return bytesArray,nil
+}
+
+/*
+	First page of the brokerage requests the caller can see, query_brokerage_requests without a selector
+*/
+func (t *SimpleChaincode) get_all_brokerage_requests(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+
+	return t.query_brokerage_requests(stub, []string{""})
+}
+
+func (t *SimpleChaincode) get_accessor(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		accessorId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	a, err := t.get_accessor_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(a)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_shared_kyc(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		requestId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	brokerageRequest, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if brokerageRequest.KYCPackageRef == "" {
+		return nil, errors.New("Brokerage request " + args[0] + " does not reference a shared KYC package")
+	}
+	if brokerageRequest.Approver != username && brokerageRequest.Submitter != username {
+		return nil, errors.New("Permission denied")
+	}
+
+	/**** The broker can only read the package while the customer's consent stands ****/
+	if brokerageRequest.Approver == username {
+		bytes, err := stub.GetState(consent_key(brokerageRequest.KYCPackageRef, username))
+		if err != nil || bytes == nil {
+			return nil, errors.New("No consent given to " + username)
+		}
+		var consent KYCConsent
+		json.Unmarshal(bytes, &consent)
+		if !consent.Active {
+			return nil, errors.New("Consent given to " + username + " has been revoked")
+		}
+	}
+
+	k, err := t.get_kyck_user_struct(stub, brokerageRequest.KYCPackageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(k)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_kyc_due_for_refresh(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		number of days ahead
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 0 {
+		return nil, errors.New("Number of days must be a non-negative integer")
+	}
+
+	/**** Compliance officers see the customers of their organisation, admins everyone ****/
+	role, _ := t.get_role(stub)
+	if role != roleAdmin {
+		if _, err := t.caller_accessor_with_role(stub, roleComplianceOfficer); err != nil {
+			return nil, errors.New("Permission denied. Only compliance officers and admins can list KYC refreshes")
+		}
+	}
+	scope, err := t.caller_scope(stub)
+	if err != nil {
+		return nil, err
+	}
+	customers, err := org_customers(stub, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+	today, _ := time.Parse(dateLayout, now.Format(dateLayout))
+	horizon := today.AddDate(0, 0, days).Format(dateLayout)
+
+	keys, values, err := range_by_prefix(stub, kyckUserPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	due := []KYCRefreshDue{}
+	for i := range keys {
+		var k KyckUser
+		err = json.Unmarshal(values[i], &k)
+		if err != nil {
+			return nil, errors.New("Corrupt KYC package under " + keys[i])
+		}
+		if !k.Verified || k.KYCExpiryDate == "" || k.KYCExpiryDate > horizon {
+			continue
+		}
+		if !scope.All && !customers[k.UserId] {
+			continue
+		}
+
+		expiry, _ := time.Parse(dateLayout, k.KYCExpiryDate)
+		due = append(due, KYCRefreshDue{
+			UserId:        k.UserId,
+			RiskCategory:  k.RiskCategory,
+			KYCExpiryDate: k.KYCExpiryDate,
+			DaysLeft:      int(expiry.Sub(today).Hours() / 24),
+		})
+	}
+
+	/**** Most overdue first ****/
+	sort.Sort(byExpiryDate(due))
+
+	bytes, _ := json.Marshal(due)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_screening(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		screeningId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	result, err := t.get_screening_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_screening_access(stub, result.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(result)
+	return bytes, nil
+}
+
+/**** Screenings name possible sanctions and PEP matches, only the customer, compliance officers and admins read them ****/
+func (t *SimpleChaincode) check_screening_access(stub *shim.ChaincodeStub, userId string) error {
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return err
+	}
+	role, _ := t.get_role(stub)
+	if username == userId || role == roleAdmin {
+		return nil
+	}
+	if _, err := t.caller_accessor_with_role(stub, roleComplianceOfficer); err == nil {
+		return nil
+	}
+	return errors.New("Permission denied. Only " + userId + ", a compliance officer or an admin can read their screenings")
+}
+
+func (t *SimpleChaincode) get_latest_screening(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		userId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := t.check_screening_access(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	screeningId, err := stub.GetState(latestScreeningPrefix + args[0])
+	if err != nil || screeningId == nil {
+		return nil, errors.New("No screening recorded for " + args[0])
+	}
+
+	return t.get_screening(stub, []string{string(screeningId)})
+}
+
+func (t *SimpleChaincode) get_record_version(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		user or thing ID
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	/**** Only users and things are versioned, other keys are not looked up ****/
+	allowed, err := t.raw_read_allowed(stub, usersIndexStr, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		allowed, err = t.raw_read_allowed(stub, thingsIndexStr, args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !allowed {
+		return nil, errors.New(args[0] + " does not exist")
+	}
+
+	version, err := get_version(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.Itoa(version)), nil
+}
+
+func (t *SimpleChaincode) verify_indexes(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can verify indexes")
+	}
+
+	reports := []IndexDriftReport{}
+	for _, i := range indexes {
+		report, err := t.index_drift(stub, i)
+		if err != nil {
+			return nil, err
+		}
+		report.DryRun = true
+		reports = append(reports, report)
+	}
+
+	bytes, _ := json.Marshal(reports)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_audit_log(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		subject, e.g. an index name or a user ID
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		return nil, errors.New("Permission denied. Only an admin can read the audit log")
+	}
+
+	entries, err := t.get_audit_entries(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(entries)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_history(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0						1
+	//		"user", "thing"		ID
+	//		"request" or "entity"
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	var indexStr string
+	if args[0] == "user" {
+		indexStr = usersIndexStr
+	} else if args[0] == "thing" {
+		indexStr = thingsIndexStr
+	} else if args[0] == "request" {
+		indexStr = applicationIndexStr
+	} else if args[0] == "entity" {
+		indexStr = entityPrefix
+	} else {
+		return nil, errors.New("Record type must be user, thing, request or entity")
+	}
+
+	err := t.check_history_access(stub, indexStr, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	keys, values, err := range_by_prefix(stub, history_key_prefix(indexStr, args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	history := []HistoryEntry{}
+	for i := range keys {
+		var entry HistoryEntry
+		err = json.Unmarshal(values[i], &entry)
+		if err != nil {
+			return nil, errors.New("Corrupt history entry " + keys[i])
+		}
+
+		// Old passwords stay out of the history as well
+		if indexStr == usersIndexStr {
+			var u User
+			if json.Unmarshal(entry.Value, &u) == nil {
+				u.Salt = ""
+				u.Hash = ""
+				entry.Value, _ = json.Marshal(u)
+			}
+		}
+		history = append(history, entry)
+	}
+
+	bytes, _ := json.Marshal(history)
+	return bytes, nil
+}
+
+/**** The history of a record is readable by whoever may read the record: the user themselves, the parties and
+      organisation of a request, the readers of an entity. Admins read everything. ****/
+func (t *SimpleChaincode) check_history_access(stub *shim.ChaincodeStub, indexStr string, id string) error {
+
+	scope, err := t.caller_scope(stub)
+	if err != nil {
+		return err
+	}
+
+	if indexStr == usersIndexStr {
+		role, _ := t.get_role(stub)
+		if scope.UserId != id && role != roleAdmin {
+			return errors.New("Permission denied. Only " + id + " or an admin can read their history")
+		}
+	} else if scope.All {
+		return nil
+	} else if indexStr == applicationIndexStr {
+		b, err := t.get_brokerage_request_struct(stub, id)
+		if err != nil {
+			return err
+		}
+		return t.check_request_readable(stub, &scope, b)
+	} else if indexStr == entityPrefix {
+		e, err := t.get_entity_struct(stub, id)
+		if err != nil {
+			return err
+		}
+		allowed, err := t.can_read_entity(stub, e)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return errors.New("Permission denied. Cannot read the history of entity " + id)
+		}
+	}
+	return nil
+}
+
+func (t *SimpleChaincode) get_kyc_dossier(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		userId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	dossier, err := t.build_kyc_dossier(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(dossier)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) verify_kyc_dossier(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		dossier JSON object as returned by get_kyc_dossier
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	var dossier KYCDossier
+	err := json.Unmarshal([]byte(args[0]), &dossier)
+	if err != nil {
+		return nil, errors.New("Invalid dossier JSON")
+	}
+
+	result := DossierVerification{Mismatches: []string{}}
+
+	for name, section := range dossier.Sections {
+		var v interface{}
+		decoder := json.NewDecoder(strings.NewReader(string(section)))
+		decoder.UseNumber()
+		if decoder.Decode(&v) != nil {
+			result.Mismatches = append(result.Mismatches, name)
+			continue
+		}
+		canonical, err := json.Marshal(v)
+		if err != nil || sha256_hex(canonical) != dossier.Manifest[name] {
+			result.Mismatches = append(result.Mismatches, name)
+		}
+	}
+	for name := range dossier.Manifest {
+		if _, ok := dossier.Sections[name]; !ok {
+			result.Mismatches = append(result.Mismatches, name)
+		}
+	}
+	sort.Strings(result.Mismatches)
+
+	manifest, _ := json.Marshal(dossier.Manifest)
+	result.Intact = len(result.Mismatches) == 0 && sha256_hex(manifest) == dossier.PackageHash
+
+	exportAsBytes, err := stub.GetState(dossierPrefix + dossier.PackageHash)
+	if err != nil {
+		return nil, errors.New("Failed to get dossier export")
+	}
+	if exportAsBytes != nil {
+		var export DossierExport
+		json.Unmarshal(exportAsBytes, &export)
+		result.Recorded = export.UserId == dossier.UserId
+		result.ExportedAt = export.ExportedAt
+	}
+
+	bytes, _ := json.Marshal(result)
+	return bytes, nil
+}
+
+/*
+	Entities are visible to whoever registered them, their directors, owners and signatories, brokers, compliance
+	officers, regulators and admins.
+*/
+func (t *SimpleChaincode) get_entity(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		entityId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	e, err := t.get_entity_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := t.can_read_entity(stub, e)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errors.New("Permission denied")
+	}
+
+	bytes, _ := json.Marshal(e)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) check_entity_ubos(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		entityId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	e, err := t.get_entity_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := t.can_read_entity(stub, e)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errors.New("Permission denied")
+	}
+
+	checks, err := t.entity_ubo_checks(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(checks)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) can_read_entity(stub *shim.ChaincodeStub, e KyckEntity) (bool, error) {
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return false, err
+	}
+	role, _ := t.get_role(stub)
+	if username == e.CreatedBy || role == roleAdmin {
+		return true, nil
+	}
+	for _, p := range append(append([]RelatedParty{}, e.Directors...), e.Signatories...) {
+		if p.UserId == username {
+			return true, nil
+		}
+	}
+	for _, o := range e.BeneficialOwners {
+		if o.UserId == username {
+			return true, nil
+		}
+	}
+
+	for _, r := range []string{roleBroker, roleComplianceOfficer, roleRegulator} {
+		if _, err := t.caller_accessor_with_role(stub, r); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *SimpleChaincode) get_approval_status(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		requestId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	b, err := t.get_brokerage_request_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	scope, err := t.caller_scope(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_request_readable(stub, &scope, b)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := t.approval_status(stub, b)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(status)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_review_queue(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		reviewerId, empty for the unassigned requests
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := t.get_role(stub)
+
+	/**** Own queue, or the queues of the caller's organisation for operations ****/
+	if username != args[0] && role != roleAdmin {
+		caller, err := t.get_accessor_struct(stub, username)
+		if err != nil {
+			return nil, errors.New("Permission denied")
+		}
+		if args[0] == "" {
+			if !has_role(caller, roleBroker) && !has_role(caller, roleOperations) {
+				return nil, errors.New("Permission denied")
+			}
+		} else {
+			reviewer, err := t.get_accessor_struct(stub, args[0])
+			if err != nil {
+				return nil, err
+			}
+			if !has_role(caller, roleOperations) || caller.Organisation == "" || caller.Organisation != reviewer.Organisation {
+				return nil, errors.New("Permission denied")
+			}
+		}
+	}
+
+	entries, err := t.open_queue_entries(stub, func(b BrokerageRequest) bool {
+		return b.Approver == args[0]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(entries)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_sla_breaches(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	/**** Admins see every breach, operations the unassigned ones and those of their organisation ****/
+	organisation := ""
+	role, err := t.get_role(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != roleAdmin {
+		caller, err := t.caller_accessor_with_role(stub, roleOperations)
+		if err != nil || caller.Organisation == "" {
+			return nil, errors.New("Permission denied. Only operations accessors and admins can list SLA breaches")
+		}
+		organisation = caller.Organisation
+	}
+
+	reviewerOrgs := map[string]string{}
+	entries, err := t.open_queue_entries(stub, func(b BrokerageRequest) bool {
+		if organisation == "" || b.Approver == "" {
+			return true
+		}
+		if _, ok := reviewerOrgs[b.Approver]; !ok {
+			a, _ := t.get_accessor_struct(stub, b.Approver)
+			reviewerOrgs[b.Approver] = a.Organisation
+		}
+		return reviewerOrgs[b.Approver] == organisation
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	breaches := []QueueEntry{}
+	for _, e := range entries {
+		if e.SLABreached {
+			breaches = append(breaches, e)
+		}
+	}
+
+	bytes, _ := json.Marshal(breaches)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_pending_notifications(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		maximum number of notifications, oldest first
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	limit, err := strconv.Atoi(args[0])
+	if err != nil || limit <= 0 {
+		return nil, errors.New("Limit must be a positive number")
+	}
+
+	if _, err := t.caller_accessor_with_role(stub, roleNotifier); err != nil {
+		return nil, errors.New("Permission denied. Only notifiers can read the outbox")
+	}
+
+	keys, values, err := range_by_prefix(stub, outboxPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []Notification{}
+	for i := range keys {
+		if len(notifications) == limit {
+			break
+		}
+		var n Notification
+		err = json.Unmarshal(values[i], &n)
+		if err != nil {
+			return nil, errors.New("Corrupt notification " + keys[i])
+		}
+
+		/**** The address is looked up at delivery, the outbox on the ledger does not hold it ****/
+		channel, address := t.recipient_contact(stub, n.Recipient)
+		n.Channel = channel
+		n.Address = address
+		notifications = append(notifications, n)
+	}
+
+	bytes, _ := json.Marshal(notifications)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_profile_changes(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		userId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := t.get_role(stub)
+	if username != args[0] && role != roleAdmin {
+		if _, err := t.caller_accessor_with_role(stub, roleDocVerifier); err != nil {
+			return nil, errors.New("Permission denied")
+		}
+	}
+
+	changes, err := t.get_profile_change_list(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := json.Marshal(changes)
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) get_contact_verification(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		userId
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := t.get_role(stub)
+	if username != args[0] && role != roleAdmin {
+		if _, err := t.caller_accessor_with_role(stub, roleNotifier); err != nil {
+			return nil, errors.New("Permission denied")
+		}
+	}
+
+	u, err := t.get_user_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	verifications := []ContactVerification{}
+	for _, channel := range []string{channelEmail, channelSMS} {
+		v, err := t.get_contact_verification_struct(stub, args[0], channel)
+		if err != nil {
+			return nil, err
+		}
+
+		/**** A verification lapses when the user changes the address ****/
+		current, _ := contact_address(u, channel)
+		if v.Address != current {
+			v.Verified = false
+			v.VerifiedAt = ""
+		}
+		v.CodeHash = ""
+		v.CodeSalt = ""
+		verifications = append(verifications, v)
+	}
+
+	bytes, _ := json.Marshal(verifications)
+	return bytes, nil
+}
+
+/*
+	An accessor the attestation was presented to checks it. The claim is evaluated again against the current KYC
+	package, so an attestation lapses with the package. Only the claim and the outcome are returned.
+*/
+func (t *SimpleChaincode) verify_attestation(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		attestationId	digest as received from the customer
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	username, err := t.get_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := t.get_attestation_struct(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	presented, err := stub.GetState(presentationPrefix + a.AttestationId + "|" + username)
+	if err != nil || presented == nil {
+		return nil, errors.New("Permission denied. Attestation " + args[0] + " has not been presented to " + username)
+	}
+
+	result := AttestationVerification{AttestationId: a.AttestationId, ClaimType: a.ClaimType, ClaimValue: a.ClaimValue}
+	if args[1] != a.Digest || attestation_digest(a) != a.Digest {
+		result.Reason = "Digest does not match"
+	} else if a.Revoked {
+		result.Reason = "Revoked"
+	} else if k, err := t.get_kyck_user_struct(stub, a.Subject); err != nil {
+		result.Reason = err.Error()
+	} else if k.SourceRequestID != a.SourceRequestID {
+		result.Reason = "KYC package has been replaced"
+	} else if err := evaluate_claim(k, a.ClaimType, a.ClaimValue, now); err != nil {
+		result.Reason = err.Error()
+	} else {
+		result.Valid = true
+	}
+
+	bytes, _ := json.Marshal(result)
+	return bytes, nil
 }
@@ -0,0 +1,810 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decode_record(t *testing.T, data string) map[string]interface{} {
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		t.Fatalf("bad test record %s: %v", data, err)
+	}
+	return record
+}
+
+func TestSelectorMatches(t *testing.T) {
+
+	record := `{"Status":"APPROVED","Score":42,"PEP":false,"Inputs":{"Country":"DE"}}`
+
+	tests := []struct {
+		name     string
+		selector string
+		want     bool
+	}{
+		{"empty selector", `{}`, true},
+		{"equal value", `{"Status":"APPROVED"}`, true},
+		{"different value", `{"Status":"REJECTED"}`, false},
+		{"nested field", `{"Inputs.Country":"DE"}`, true},
+		{"number range", `{"Score":{"$gte":40,"$lt":50}}`, true},
+		{"number out of range", `{"Score":{"$gt":42}}`, false},
+		{"prefix", `{"Status":{"$prefix":"APP"}}`, true},
+		{"bool equal", `{"PEP":false}`, true},
+		{"missing field $ne", `{"Closure":{"$ne":"x"}}`, true},
+		{"missing field $eq", `{"Closure":"x"}`, false},
+		{"missing field $ne does not skip other fields", `{"Closure":{"$ne":"x"},"Status":"REJECTED"}`, false},
+		{"missing field $ne and other operator", `{"Closure":{"$ne":"x","$gt":"a"}}`, false},
+		{"type mismatch $ne", `{"Score":{"$ne":"42"}}`, true},
+		{"type mismatch $eq", `{"Score":"42"}`, false},
+		{"type mismatch $ne does not skip other fields", `{"Score":{"$ne":"42"},"Status":"REJECTED"}`, false},
+		{"bool ordering", `{"PEP":{"$gt":false}}`, false},
+	}
+
+	for _, tt := range tests {
+		got := selector_matches(decode_record(t, tt.selector), decode_record(t, record))
+		if got != tt.want {
+			t.Errorf("%s: selector_matches(%s) = %v, want %v", tt.name, tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestParseListingQuery(t *testing.T) {
+
+	tests := []struct {
+		arg       string
+		wantLimit int
+		wantErr   bool
+	}{
+		{``, defaultPageSize, false},
+		{`{"limit":10}`, 10, false},
+		{`{"limit":100000}`, maxPageSize, false},
+		{`{"selector":{"Status":{"$regex":"A"}}}`, 0, true},
+		{`not json`, 0, true},
+	}
+
+	for _, tt := range tests {
+		q, err := parse_listing_query(tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parse_listing_query(%q) error = %v, wantErr %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if err == nil && q.Limit != tt.wantLimit {
+			t.Errorf("parse_listing_query(%q) limit = %d, want %d", tt.arg, q.Limit, tt.wantLimit)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"accessor_", "accessor`"},
+		{"_resources_bob|", "_resources_bob}"},
+		{"a\xff", "b"},
+		{"\xff", ""},
+	}
+
+	for _, tt := range tests {
+		if got := prefix_end(tt.prefix); got != tt.want {
+			t.Errorf("prefix_end(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+
+	// Every key with the prefix sorts below the end key, including ones past "~"
+	for _, key := range []string{"accessor_~", "accessor_é", "accessor_\xff\xff"} {
+		if !(key < prefix_end("accessor_")) {
+			t.Errorf("%q is not below prefix_end(accessor_)", key)
+		}
+	}
+}
+
+func TestCheckRecordId(t *testing.T) {
+
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{"alice", false},
+		{"thing42", false},
+		{"historyfan", false},
+		{"", true},
+		{"accessor_alice", true},
+		{"kyckuser_alice", true},
+		{"screeninguser_alice", true},
+		{"private_org1|req1", true},
+		{"_version_alice", true},
+		{"history_users_alice|1", true},
+		{"17BrokerageRequests", true},
+		{"org1|alice", true},
+	}
+
+	for _, tt := range tests {
+		err := check_record_id(tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("check_record_id(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStoredStatus(t *testing.T) {
+
+	tests := []struct {
+		column string
+		want   string
+	}{
+		{"APPROVED", "APPROVED"},
+		{"", ""},
+		{`{"RequestID":"r1","UpdateType":"STATUS","Status":"REJECTED"}`, "REJECTED"},
+		{`{not json`, `{not json`},
+	}
+
+	for _, tt := range tests {
+		if got := stored_status(tt.column); got != tt.want {
+			t.Errorf("stored_status(%q) = %q, want %q", tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestRiskiestCountry(t *testing.T) {
+
+	model := RiskModel{CountryPoints: map[string]int{"DE": 1, "IR": 30}, DefaultPoints: 10}
+
+	tests := []struct {
+		countries []string
+		want      string
+	}{
+		{[]string{"DE"}, "DE"},
+		{[]string{"DE", "IR"}, "IR"},
+		{[]string{"DE", "XX"}, "XX"},
+		{[]string{"XX", "YY"}, "XX"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := riskiest_country(model, tt.countries); got != tt.want {
+			t.Errorf("riskiest_country(%v) = %q, want %q", tt.countries, got, tt.want)
+		}
+	}
+}
+
+func TestScoreRisk(t *testing.T) {
+
+	model := RiskModel{
+		CountryPoints:   map[string]int{"DE": 0, "IR": 40},
+		PEPPoints:       20,
+		VolumeBands:     []VolumeBand{{Min: 0, Max: 100000, Points: 0}, {Min: 100000, Points: 20}},
+		DefaultPoints:   5,
+		MediumThreshold: 20,
+		HighThreshold:   40,
+	}
+
+	tests := []struct {
+		name     string
+		inputs   RiskInputs
+		category string
+		edd      bool
+	}{
+		{"low", RiskInputs{Country: "DE", Occupation: "x", ProductType: "y"}, riskLow, false},
+		{"medium by volume", RiskInputs{Country: "DE", TransactionVolume: 500000}, riskMedium, false},
+		{"high by country", RiskInputs{Country: "IR"}, riskHigh, true},
+		{"pep needs edd", RiskInputs{Country: "DE", PEP: true}, riskMedium, true},
+	}
+
+	for _, tt := range tests {
+		a := score_risk(model, tt.inputs)
+		if a.Category != tt.category || a.EDDRequired != tt.edd {
+			t.Errorf("%s: category %s edd %v, want %s %v (score %d)", tt.name, a.Category, a.EDDRequired, tt.category, tt.edd, a.Score)
+		}
+	}
+}
+
+func TestOnlyPepMatches(t *testing.T) {
+
+	tests := []struct {
+		matches []ScreeningMatch
+		want    bool
+	}{
+		{nil, false},
+		{[]ScreeningMatch{{List: "PEP"}}, true},
+		{[]ScreeningMatch{{List: "World PEP list"}, {List: "pep"}}, true},
+		{[]ScreeningMatch{{List: "PEP"}, {List: "OFAC SDN"}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := only_pep_matches(ScreeningResult{Matches: tt.matches}); got != tt.want {
+			t.Errorf("only_pep_matches(%v) = %v, want %v", tt.matches, got, tt.want)
+		}
+	}
+}
+
+func TestUnmatchedRoles(t *testing.T) {
+
+	approval := func(reviewer string, roles ...string) ApprovalDecision {
+		return ApprovalDecision{Reviewer: reviewer, Roles: roles, Decision: decisionApprove}
+	}
+
+	tests := []struct {
+		name      string
+		required  []string
+		approvals []ApprovalDecision
+		want      int
+	}{
+		{"nothing required", nil, nil, 0},
+		{"one reviewer per role", []string{"broker", "compliance_officer"},
+			[]ApprovalDecision{approval("a", "broker"), approval("b", "compliance_officer")}, 0},
+		{"one reviewer holding both roles", []string{"broker", "compliance_officer"},
+			[]ApprovalDecision{approval("a", "broker", "compliance_officer")}, 1},
+		{"reviewer moved to the role only they can fill", []string{"broker", "compliance_officer"},
+			[]ApprovalDecision{approval("a", "broker", "compliance_officer"), approval("b", "broker")}, 0},
+		{"same role twice", []string{"broker", "broker"},
+			[]ApprovalDecision{approval("a", "broker")}, 1},
+		{"no approvals", []string{"broker"}, nil, 1},
+	}
+
+	for _, tt := range tests {
+		if got := unmatched_roles(tt.required, tt.approvals); len(got) != tt.want {
+			t.Errorf("%s: unmatched_roles = %v, want %d missing", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReviewAge(t *testing.T) {
+
+	submitted := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := submitted.Add(100 * time.Hour)
+	at := func(hours int) string { return submitted.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339) }
+
+	tests := []struct {
+		name     string
+		requests []InfoRequest
+		want     time.Duration
+	}{
+		{"no requests for information", nil, 100 * time.Hour},
+		{"answered request", []InfoRequest{{RequestedAt: at(10), Response: &InfoResponse{RespondedAt: at(40)}}}, 70 * time.Hour},
+		{"open request", []InfoRequest{{RequestedAt: at(60)}}, 60 * time.Hour},
+		{"answered and open", []InfoRequest{
+			{RequestedAt: at(10), Response: &InfoResponse{RespondedAt: at(20)}},
+			{RequestedAt: at(90)}}, 80 * time.Hour},
+		{"unreadable time is ignored", []InfoRequest{{RequestedAt: "yesterday"}}, 100 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := review_age(submitted, now, tt.requests); got != tt.want {
+			t.Errorf("%s: review_age = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCodeHash(t *testing.T) {
+
+	salt := "00112233445566778899aabbccddeeff"
+	want := code_hash(salt, "alice", "EMAIL", "K7Q2M9X4PZ")
+
+	tests := []struct {
+		name  string
+		salt  string
+		code  string
+		match bool
+	}{
+		{"same code and salt", salt, "K7Q2M9X4PZ", true},
+		{"other code", salt, "K7Q2M9X4PY", false},
+		{"other salt", "ffeeddccbbaa99887766554433221100", "K7Q2M9X4PZ", false},
+		{"unreadable salt", "not hex", "K7Q2M9X4PZ", false},
+		{"no salt", "", "K7Q2M9X4PZ", false},
+	}
+
+	if len(want) != 64 {
+		t.Fatalf("code_hash returned %q", want)
+	}
+	for _, tt := range tests {
+		if got := code_hash(tt.salt, "alice", "EMAIL", tt.code) == want; got != tt.match {
+			t.Errorf("%s: match = %v, want %v", tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestSealedValue(t *testing.T) {
+
+	value := `{"firstName":"Alice"}`
+	salted := sealed_value("salt1", value)
+
+	tests := []struct {
+		name  string
+		got   string
+		check func(string) bool
+	}{
+		{"empty stays empty", sealed_value("salt1", ""), func(s string) bool { return s == "" }},
+		{"salted hash", salted, func(s string) bool { return len(s) == len(sealedPrefix)+64 && s[:len(sealedPrefix)] == sealedPrefix }},
+		{"same salt same hash", sealed_value("salt1", value), func(s string) bool { return s == salted }},
+		{"other salt other hash", sealed_value("salt2", value), func(s string) bool { return s != salted }},
+		{"no salt is the unsalted hash", sealed_value("", value), func(s string) bool { return s == legacySealedPrefix+sha256_hex([]byte(value)) }},
+		{"sealed values are not sealed twice", sealed_value("salt2", salted), func(s string) bool { return s == salted }},
+		{"unsalted hash is resealed", resealed_value("salt1", sealed_value("", value)), func(s string) bool { return s != sealed_value("", value) && is_sealed(s) && !legacy_sealed(s) }},
+	}
+
+	for _, tt := range tests {
+		if !tt.check(tt.got) {
+			t.Errorf("%s: got %q", tt.name, tt.got)
+		}
+	}
+}
+
+func TestLegacySealed(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"plain", true},
+		{legacySealedPrefix + "abc", true},
+		{sealedPrefix + "abc", false},
+	}
+
+	for _, tt := range tests {
+		if got := legacy_sealed(tt.value); got != tt.want {
+			t.Errorf("legacy_sealed(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateClaim(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	details := `{"DateOfBirth":"2000-01-15","Addresses":[{"Type":"MAILING","Country":"US"},{"Type":"RESIDENTIAL","Country":"DE"}]}`
+	verified := KyckUser{UserId: "alice", Verified: true, KYCExpiryDate: "2025-01-01", PersonalDetails: []byte(details)}
+
+	edd := verified
+	edd.RiskAssessment = &RiskAssessment{EDDRequired: true}
+	eddApproved := edd
+	eddApproved.EDDApprovedBy = "carol"
+
+	unverified := verified
+	unverified.Verified = false
+	expired := verified
+	expired.KYCExpiryDate = "2024-05-31"
+	expiresToday := verified
+	expiresToday.KYCExpiryDate = "2024-06-01"
+
+	tests := []struct {
+		name  string
+		k     KyckUser
+		claim string
+		value string
+		holds bool
+	}{
+		{"over 18", verified, claimAgeOver, "18", true},
+		{"not over 25", verified, claimAgeOver, "25", false},
+		{"bad age", verified, claimAgeOver, "-1", false},
+		{"resident in DE", verified, claimResidentIn, "DE", true},
+		{"resident in the EU", verified, claimResidentIn, "EU", true},
+		{"mailing address does not count", verified, claimResidentIn, "US", false},
+		{"unknown country", verified, claimResidentIn, "XX", false},
+		{"standard level", verified, claimKYCLevel, kycLevelStandard, true},
+		{"enhanced without EDD", verified, claimKYCLevel, kycLevelEnhanced, false},
+		{"enhanced with unapproved EDD", edd, claimKYCLevel, kycLevelEnhanced, false},
+		{"enhanced with approved EDD", eddApproved, claimKYCLevel, kycLevelEnhanced, true},
+		{"unverified package", unverified, claimAgeOver, "18", false},
+		{"expired package", expired, claimAgeOver, "18", false},
+		{"package expiring today", expiresToday, claimAgeOver, "18", false},
+		{"unknown claim", verified, "INCOME_OVER", "1", false},
+	}
+
+	for _, tt := range tests {
+		err := evaluate_claim(tt.k, tt.claim, tt.value, now)
+		if (err == nil) != tt.holds {
+			t.Errorf("%s: evaluate_claim(%s, %s) error = %v, want holds %v", tt.name, tt.claim, tt.value, err, tt.holds)
+		}
+	}
+}
+
+func TestApprovalOutcome(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		decision  string
+		satisfied bool
+		want      string
+	}{
+		{"rejection rejects", decisionReject, false, statusRejected},
+		{"rejection rejects a satisfied policy", decisionReject, true, statusRejected},
+		{"last approval completes the policy", decisionApprove, true, statusApproved},
+		{"approval with roles still missing", decisionApprove, false, ""},
+	}
+
+	for _, tt := range tests {
+		if got := approval_outcome(tt.decision, ApprovalStatus{Satisfied: tt.satisfied}); got != tt.want {
+			t.Errorf("%s: approval_outcome = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyEntity(t *testing.T) {
+
+	// The policy path approves without the approver's STATUS update, the entity is verified all the same
+	b := BrokerageRequest{RequestID: "r1", Approver: "bob", EntityId: "acme"}
+	b.Status = approval_outcome(decisionApprove, ApprovalStatus{Satisfied: true})
+
+	e := KyckEntity{EntityId: "acme"}
+	if b.Status == statusApproved {
+		verify_entity(&e, b)
+	}
+	if !e.Verified || e.VerifiedBy != "bob" || e.SourceRequestID != "r1" {
+		t.Errorf("entity after policy approval = %+v, want verified by bob on r1", e)
+	}
+}
+
+func TestKycExpired(t *testing.T) {
+
+	tests := []struct {
+		expiry  string
+		expired bool
+	}{
+		{"", false},
+		{"2024-05-31", true},
+		{"2024-06-01", true},
+		{"2024-06-02", false},
+	}
+
+	for _, tt := range tests {
+		if got := kyc_expired(tt.expiry, "2024-06-01"); got != tt.expired {
+			t.Errorf("kyc_expired(%q) = %v, want %v", tt.expiry, got, tt.expired)
+		}
+	}
+}
+
+func TestKeepUnsetFields(t *testing.T) {
+
+	stored := map[string]json.RawMessage{
+		"userId":      json.RawMessage(`"alice"`),
+		"hash":        json.RawMessage(`"h"`),
+		"phoneNumber": json.RawMessage(`"123"`),
+	}
+	updated := map[string]json.RawMessage{
+		"phoneNumber": json.RawMessage(`"456"`),
+		"lastName":    json.RawMessage(`"Smith"`),
+	}
+
+	keep_unset_fields(updated, stored)
+
+	want := map[string]string{"userId": `"alice"`, "hash": `"h"`, "phoneNumber": `"456"`, "lastName": `"Smith"`}
+	if len(updated) != len(want) {
+		t.Fatalf("keep_unset_fields gave %d fields, want %d", len(updated), len(want))
+	}
+	for field, value := range want {
+		if string(updated[field]) != value {
+			t.Errorf("%s = %s, want %s", field, updated[field], value)
+		}
+	}
+}
+
+func TestValidatePersonalDetails(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	home := Address{Type: addressResidential, Line1: "1 Main St", City: "Berlin", Country: "DE"}
+	office := Address{Type: addressRegistered, Line1: "2 Dock Rd", City: "London", Country: "GB"}
+
+	individual := PersonalDetails{CustomerType: customerIndividual, FirstName: "Alice", LastName: "Smith",
+		DateOfBirth: "1990-01-15", Nationality: "DE", Addresses: []Address{home}}
+	corporate := PersonalDetails{CustomerType: customerCorporate, LegalName: "Acme Ltd", RegistrationNumber: "123",
+		IncorporationCountry: "GB", Addresses: []Address{office}}
+
+	noName := individual
+	noName.LastName = ""
+	unborn := individual
+	unborn.DateOfBirth = "2030-01-01"
+	badNationality := individual
+	badNationality.Nationality = "XX"
+	mailingOnly := individual
+	mailingOnly.Addresses = []Address{{Type: addressMailing, Line1: "1 Main St", City: "Berlin", Country: "DE"}}
+	badAddress := individual
+	badAddress.Addresses = []Address{home, {Type: addressMailing, City: "Berlin", Country: "DE"}}
+	badAddressType := individual
+	badAddressType.Addresses = []Address{{Type: "HOLIDAY", Line1: "1 Main St", City: "Berlin", Country: "DE"}}
+
+	noRegistration := corporate
+	noRegistration.RegistrationNumber = ""
+	futureIncorporation := corporate
+	futureIncorporation.IncorporationDate = "2030-01-01"
+	noRegisteredAddress := corporate
+	noRegisteredAddress.Addresses = []Address{home}
+	unknownType := individual
+	unknownType.CustomerType = "TRUST"
+
+	tests := []struct {
+		name    string
+		p       PersonalDetails
+		wantErr bool
+	}{
+		{"individual", individual, false},
+		{"corporate", corporate, false},
+		{"individual without last name", noName, true},
+		{"date of birth in the future", unborn, true},
+		{"unknown nationality", badNationality, true},
+		{"individual without residential address", mailingOnly, true},
+		{"address without line 1", badAddress, true},
+		{"unknown address type", badAddressType, true},
+		{"corporate without registration number", noRegistration, true},
+		{"incorporated in the future", futureIncorporation, true},
+		{"corporate without registered address", noRegisteredAddress, true},
+		{"unknown customer type", unknownType, true},
+	}
+
+	for _, tt := range tests {
+		err := validate_personal_details(tt.p, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate_personal_details error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateKycDetails(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	passport := IdDocument{Type: "PASSPORT", Number: "P123", IssuingCountry: "DE", IssueDate: "2020-01-01", ExpiryDate: "2030-01-01"}
+	valid := KYCDetails{IdDocuments: []IdDocument{passport}, TaxResidencies: []TaxResidency{{Country: "DE"}},
+		Occupation: "Engineer", SourceOfFunds: "Salary"}
+
+	expiresToday := passport
+	expiresToday.ExpiryDate = "2024-06-01"
+	expired := passport
+	expired.ExpiryDate = "2024-05-31"
+	expiryBeforeIssue := passport
+	expiryBeforeIssue.IssueDate = "2020-01-01"
+	expiryBeforeIssue.ExpiryDate = "2019-12-31"
+	noNumber := passport
+	noNumber.Number = ""
+	badExpiry := passport
+	badExpiry.ExpiryDate = "01/01/2030"
+
+	with := func(d IdDocument) KYCDetails {
+		k := valid
+		k.IdDocuments = []IdDocument{d}
+		return k
+	}
+	noDocuments := valid
+	noDocuments.IdDocuments = nil
+	noTax := valid
+	noTax.TaxResidencies = nil
+	badTax := valid
+	badTax.TaxResidencies = []TaxResidency{{Country: "XX"}}
+	noOccupation := valid
+	noOccupation.Occupation = ""
+	noFunds := valid
+	noFunds.SourceOfFunds = ""
+
+	tests := []struct {
+		name         string
+		k            KYCDetails
+		customerType string
+		wantErr      bool
+	}{
+		{"individual", valid, customerIndividual, false},
+		{"document expiring today", with(expiresToday), customerIndividual, false},
+		{"expired document", with(expired), customerIndividual, true},
+		{"expiry before issue", with(expiryBeforeIssue), customerIndividual, true},
+		{"document without number", with(noNumber), customerIndividual, true},
+		{"expiry not a date", with(badExpiry), customerIndividual, true},
+		{"individual without documents", noDocuments, customerIndividual, true},
+		{"corporate without documents", noDocuments, customerCorporate, false},
+		{"no tax residency", noTax, customerIndividual, true},
+		{"unknown tax residency", badTax, customerIndividual, true},
+		{"individual without occupation", noOccupation, customerIndividual, true},
+		{"corporate without occupation", noOccupation, customerCorporate, false},
+		{"no source of funds", noFunds, customerCorporate, true},
+	}
+
+	for _, tt := range tests {
+		err := validate_kyc_details(tt.k, tt.customerType, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate_kyc_details error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDocumentCheckVerdict(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	passport := DocumentCheck{DocumentType: "PASSPORT", IssuingCountry: "DE", ExpiryDate: "2030-01-01", MRZVerdict: verdictPass}
+
+	licence := passport
+	licence.MRZVerdict = verdictNotApplicable
+	mrzFailed := passport
+	mrzFailed.MRZVerdict = verdictFail
+	mrzUnread := passport
+	mrzUnread.MRZVerdict = ""
+	tampered := passport
+	tampered.TamperFlags = []string{"PHOTO_REPLACED"}
+	expiresToday := passport
+	expiresToday.ExpiryDate = "2024-06-01"
+	expired := passport
+	expired.ExpiryDate = "2024-05-31"
+	badExpiry := passport
+	badExpiry.ExpiryDate = "June 2030"
+	noExpiry := passport
+	noExpiry.ExpiryDate = ""
+	noCountry := passport
+	noCountry.IssuingCountry = ""
+	expiredNoType := expired
+	expiredNoType.DocumentType = ""
+
+	tests := []struct {
+		name  string
+		check DocumentCheck
+		want  string
+	}{
+		{"passport", passport, verdictPass},
+		{"no MRZ to read", licence, verdictPass},
+		{"MRZ failed", mrzFailed, verdictFail},
+		{"MRZ not read", mrzUnread, verdictRefer},
+		{"tampered", tampered, verdictFail},
+		{"expires today", expiresToday, verdictPass},
+		{"expired", expired, verdictFail},
+		{"expiry not a date", badExpiry, verdictRefer},
+		{"no expiry", noExpiry, verdictRefer},
+		{"no issuing country", noCountry, verdictRefer},
+		{"expired wins over missing fields", expiredNoType, verdictFail},
+	}
+
+	for _, tt := range tests {
+		if got := document_check_verdict(tt.check, now); got != tt.want {
+			t.Errorf("%s: document_check_verdict = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateVerdict(t *testing.T) {
+
+	pass := DocumentCheck{Verdict: verdictPass}
+	refer := DocumentCheck{Verdict: verdictRefer}
+	fail := DocumentCheck{Verdict: verdictFail}
+
+	tests := []struct {
+		name   string
+		checks []DocumentCheck
+		want   string
+	}{
+		{"no checks", nil, verdictPending},
+		{"all pass", []DocumentCheck{pass, pass}, verdictPass},
+		{"one refer", []DocumentCheck{pass, refer}, verdictRefer},
+		{"fail wins over refer", []DocumentCheck{refer, fail, pass}, verdictFail},
+	}
+
+	for _, tt := range tests {
+		if got := aggregate_verdict(tt.checks); got != tt.want {
+			t.Errorf("%s: aggregate_verdict = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatusMoveAllowed(t *testing.T) {
+
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{statusSubmitted, statusInReview, true},
+		{statusSubmitted, statusApproved, true},
+		{statusSubmitted, statusRejected, true},
+		{statusInReview, statusApproved, true},
+		{statusInReview, statusRejected, true},
+		{statusInReview, statusSubmitted, false},
+		{statusInfoRequested, statusInReview, true},
+		{statusInfoRequested, statusRejected, true},
+		{statusInfoRequested, statusApproved, false},
+		{statusApproved, statusRejected, false},
+		{statusApproved, statusInReview, false},
+		{statusRejected, statusApproved, false},
+		{statusWithdrawn, statusInReview, false},
+		{statusSubmitted, "UNKNOWN", false},
+	}
+
+	for _, tt := range tests {
+		if got := status_move_allowed(tt.from, tt.to); got != tt.allowed {
+			t.Errorf("status_move_allowed(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.allowed)
+		}
+	}
+}
+
+func TestIsOpenStatus(t *testing.T) {
+
+	tests := []struct {
+		status string
+		open   bool
+	}{
+		{"", true},
+		{statusSubmitted, true},
+		{statusInReview, true},
+		{statusInfoRequested, true},
+		{statusApproved, false},
+		{statusRejected, false},
+		{statusWithdrawn, false},
+	}
+
+	for _, tt := range tests {
+		if got := is_open_status(tt.status); got != tt.open {
+			t.Errorf("is_open_status(%q) = %v, want %v", tt.status, got, tt.open)
+		}
+	}
+}
+
+func TestUboRequired(t *testing.T) {
+
+	tests := []struct {
+		percent   float64
+		threshold float64
+		required  bool
+	}{
+		{30, 25, true},
+		{25, 25, true},
+		{24.99, 25, false},
+		{0, 25, false},
+		{10, 10, true},
+		{100, 100, true},
+	}
+
+	for _, tt := range tests {
+		o := BeneficialOwner{UserId: "bob", OwnershipPercent: tt.percent}
+		if got := ubo_required(o, UBOPolicy{ThresholdPercent: tt.threshold}); got != tt.required {
+			t.Errorf("ubo_required(%v%%, threshold %v%%) = %v, want %v", tt.percent, tt.threshold, got, tt.required)
+		}
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+
+	tooMany := "[" + strings.Repeat(`{},`, maxBatchSize) + "{}]"
+
+	tests := []struct {
+		name    string
+		batch   string
+		mode    string
+		items   int
+		wantErr bool
+	}{
+		{"all or nothing", `[{"userId":"a"},{"userId":"b"}]`, batchAllOrNothing, 2, false},
+		{"partial", `[{"userId":"a"}]`, batchPartial, 1, false},
+		{"unknown mode", `[{"userId":"a"}]`, "best_effort", 0, true},
+		{"not an array", `{"userId":"a"}`, batchPartial, 0, true},
+		{"empty", `[]`, batchPartial, 0, true},
+		{"too many items", tooMany, batchPartial, 0, true},
+	}
+
+	for _, tt := range tests {
+		items, result, err := parse_batch(tt.batch, tt.mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parse_batch error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (len(items) != tt.items || result.Total != tt.items || result.Mode != tt.mode) {
+			t.Errorf("%s: parse_batch gave %d items, result %+v", tt.name, len(items), result)
+		}
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+
+	failures := []BatchFailure{{Index: 1, Id: "b", Error: "userId is required"}}
+
+	tests := []struct {
+		name    string
+		result  BatchResult
+		wantErr bool
+	}{
+		{"all or nothing without failures", BatchResult{Mode: batchAllOrNothing}, false},
+		{"all or nothing with a failure", BatchResult{Mode: batchAllOrNothing, Failures: failures}, true},
+		{"partial without failures", BatchResult{Mode: batchPartial}, false},
+		{"partial with a failure", BatchResult{Mode: batchPartial, Failures: failures}, false},
+	}
+
+	for _, tt := range tests {
+		err := check_batch(tt.result)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: check_batch error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
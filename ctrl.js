@@ -33,6 +33,36 @@ exports.list = function(req, res) {
     }); 
 }
 
+/*
+    Retrieve one page of things matching a selector
+
+    METHOD: POST
+    URL : /api/v1/thing/query
+    Body:
+        { selector: {'owner': 'john', 'createdAt': {'$gte': '2016-01-01'}}, fields: ['id', 'owner'], limit: 25, bookmark: '' }
+    Response:
+        { records: [{'thing'}, {'thing'}], count: 2, bookmark: '' }
+*/
+exports.query = function(req, res) {
+    console.log("-- Query things page --")
+
+    const functionName = "query_things"
+    const args = [JSON.stringify(req.body)];
+    const enrollmentId = enrollID.getID(req);
+
+    BlockchainService.query(functionName,args,enrollmentId).then(function(page){
+        if (!page) {
+            res.json({ records: [], count: 0, bookmark: "" });
+        } else {
+            console.log("Retrieved things from the blockchain: # " + page.count);
+            res.json(page)
+        }
+    }).catch(function(err){
+        console.log("Error", err);
+        res.sendStatus(500);
+    });
+}
+
 /*
     Retrieve thing object
 
@@ -83,6 +113,59 @@ exports.add = function(req, res) {
         res.sendStatus(500);   
     }); 
 }
+/*
+    Transfer thing to another user
+
+    METHOD: POST
+    URL: /api/v1/thing/:thingId/transfer
+    Body:
+        { newOwner }
+    Response:
+        {  }
+*/
+exports.transfer = function(req, res) {
+    console.log("-- Transferring thing --")
+
+    const functionName = "transfer_thing"
+    const args = [req.params.thingId, req.body.newOwner];
+    const enrollmentId = enrollID.getID(req);
+
+    BlockchainService.invoke(functionName,args,enrollmentId).then(function(result){
+        res.sendStatus(200);
+    }).catch(function(err){
+        console.log("Error", err);
+        res.sendStatus(500);
+    });
+}
+
+/*
+    Retrieve list of things owned by a user
+
+    METHOD: GET
+    URL : /api/v1/thing/owner/:userId
+    Response:
+        [{'thing'}, {'thing'}]
+*/
+exports.listByOwner = function(req, res) {
+    console.log("-- Query things by owner --")
+
+    const functionName = "get_things_by_owner"
+    const args = [req.params.userId];
+    const enrollmentId = enrollID.getID(req);
+
+    BlockchainService.query(functionName,args,enrollmentId).then(function(things){
+        if (!things) {
+            res.json([]);
+        } else {
+            console.log("Retrieved things from the blockchain: # " + things.length);
+            res.json(things)
+        }
+    }).catch(function(err){
+        console.log("Error", err);
+        res.sendStatus(500);
+    });
+}
+
 exports.addresource = function(req, res) {
     console.log("-- Nodejs Adding resource --")
     console.log("POST BODY >>>>" + req.body);